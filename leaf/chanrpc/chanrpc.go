@@ -1,12 +1,16 @@
 package chanrpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/name5566/leaf/conf"
 	"runtime"
 )
 
+//同步调用超时错误，context的截止时间到达时返回
+var ErrTimeout = errors.New("chanrpc call timeout")
+
 // one server per goroutine (goroutine not safe)
 // one client per goroutine (goroutine not safe)
 //rpc服务器定义
@@ -19,10 +23,13 @@ type Server struct {
 	// func(args []interface{}) []interface{}
 	functions map[interface{}]interface{} //id->func映射
 	ChanCall  chan *CallInfo              //管道调用（用于传递调用信息）
+	proxy     bool                        //代理服务器标志，代理服务器不检查函数，由ChanCall的读取者转发调用
 }
 
 //调用信息
 type CallInfo struct {
+	id      interface{}   //函数id
+	n       int           //返回值个数，0，1，2(N)，Go调用为-1
 	f       interface{}   //函数
 	args    []interface{} //参数
 	chanRet chan *RetInfo //返回值管道，用于传输返回值，可能是同步返回值管道也可能是异步返回值管道
@@ -60,12 +67,21 @@ func NewServer(l int) *Server {
 }
 
 // you must call the function before calling Open and Go
+//创建代理服务器，调用不在本地执行，而是由读取ChanCall的goroutine转发（比如转发到其它进程）
+//转发者通过CallInfo的ID、Args、NumRet获取调用信息，执行完成后调用Ret返回结果
+func NewProxyServer(l int) *Server {
+	s := NewServer(l)
+	s.proxy = true
+	return s
+}
+
 //注册f(函数)
 func (s *Server) Register(id interface{}, f interface{}) {
 	switch f.(type) { //判断f的类型
 	case func([]interface{}): //参数是切片，值任意。无返回值
 	case func([]interface{}) interface{}: //参数是切片，值任意。返回值为一个任意值
 	case func([]interface{}) []interface{}: //参数是切片，返回值也是切片，值均为任意
+	case func([]interface{}) error: //参数是切片，值任意。返回的错误作为调用的错误，按无返回值调用
 	default:
 		panic(fmt.Sprintf("function id %v: definition of function is invalid", id)) //id对应的函数定义非法
 	}
@@ -94,6 +110,26 @@ func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
 	return
 }
 
+//返回调用结果，用于代理服务器的转发者
+func (s *Server) Ret(ci *CallInfo, ret interface{}, err error) error {
+	return s.ret(ci, &RetInfo{ret: ret, err: err})
+}
+
+//函数id
+func (ci *CallInfo) ID() interface{} {
+	return ci.id
+}
+
+//调用参数
+func (ci *CallInfo) Args() []interface{} {
+	return ci.args
+}
+
+//返回值个数，0，1，2(N)，Go调用（不需要返回）为-1
+func (ci *CallInfo) NumRet() int {
+	return ci.n
+}
+
 //执行RPC调用
 func (s *Server) Exec(ci *CallInfo) (err error) {
 	//延迟处理异常
@@ -121,6 +157,9 @@ func (s *Server) Exec(ci *CallInfo) (err error) {
 	case func([]interface{}) []interface{}: //n个返回值
 		ret := ci.f.(func([]interface{}) []interface{})(ci.args) //执行调用
 		return s.ret(ci, &RetInfo{ret: ret})                     //多个返回值
+	case func([]interface{}) error: //返回一个错误
+		e := ci.f.(func([]interface{}) error)(ci.args) //执行调用
+		return s.ret(ci, &RetInfo{err: e})             //错误作为调用的错误返回
 	}
 
 	panic("bug")
@@ -130,7 +169,7 @@ func (s *Server) Exec(ci *CallInfo) (err error) {
 //RPC服务器调用自己
 func (s *Server) Go(id interface{}, args ...interface{}) {
 	f := s.functions[id] //根据id取得对应的f
-	if f == nil {
+	if f == nil && !s.proxy {
 		return
 	}
 
@@ -139,6 +178,8 @@ func (s *Server) Go(id interface{}, args ...interface{}) {
 	}()
 
 	s.ChanCall <- &CallInfo{ //将调用消息通过管道传输到rpc服务器
+		id:   id,
+		n:    -1,
 		f:    f,
 		args: args,
 	}
@@ -158,11 +199,23 @@ func (s *Server) Close() {
 // goroutine safe
 //打开一个rpc客户端
 func (s *Server) Open(l int) *Client {
+	c := NewClient(l) //创建一个rpc客户端
+	c.Attach(s)       //关联rpc服务器
+	return c          //返回rpc客户端
+}
+
+//创建一个rpc客户端，调用前需要先Attach一个rpc服务器
+func NewClient(l int) *Client {
 	c := new(Client)                       //创建一个rpc客户端
-	c.s = s                                //保存rpc服务器引用
 	c.chanSyncRet = make(chan *RetInfo, 1) //创建一个管道用于传输同步调用返回信息，同步调用的管道大小一定为1，因为调用以后就需要阻塞读取返回
 	c.ChanAsynRet = make(chan *RetInfo, l) //创建一个管道用于传输异步调用返回信息，异步调用的管道大小不一定为1
-	return c                               //返回rpc客户端
+	return c
+}
+
+//关联rpc服务器，之后的调用都发往该服务器
+//已经发起的异步调用不受影响，返回值仍然通过ChanAsynRet返回
+func (c *Client) Attach(s *Server) {
+	c.s = s //保存rpc服务器引用
 }
 
 //发起调用
@@ -188,6 +241,14 @@ func (c *Client) call(ci *CallInfo, block bool) (err error) {
 
 //获取f
 func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
+	if c.s == nil {
+		err = errors.New("server not attached")
+		return
+	}
+	if c.s.proxy { //代理服务器由转发者检查函数
+		return
+	}
+
 	f = c.s.functions[id] //根据id取得对应的f
 
 	//函数f未注册
@@ -201,6 +262,9 @@ func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 	switch n {
 	case 0:
 		_, ok = f.(func([]interface{})) //n为0，无返回值
+		if !ok {
+			_, ok = f.(func([]interface{}) error) //或者只返回一个错误
+		}
 	case 1:
 		_, ok = f.(func([]interface{}) interface{}) //n为1，一个返回值
 	case 2:
@@ -216,74 +280,111 @@ func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 	return
 }
 
-//调用0
-//适合参数是切片，值任意。无返回值
-//call0 call1 calln 可以将0 1 n记作0个返回值，1个返回值，n个返回值
-func (c *Client) Call0(id interface{}, args ...interface{}) error {
+//发起调用，ctx被取消时放弃等待管道可写
+func (c *Client) callContext(ctx context.Context, ci *CallInfo) (err error) {
+	//捕获异常
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+
+	select {
+	case c.s.ChanCall <- ci: //将调用消息通过管道传输到rpc服务器
+	case <-ctx.Done():
+		err = ctxErr(ctx)
+	}
+	return
+}
+
+//将context的错误转换为chanrpc的错误，截止时间到达返回ErrTimeout
+func ctxErr(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return ctx.Err()
+}
+
+//发起同步调用并等待返回
+func (c *Client) syncCall(ctx context.Context, id interface{}, n int, args []interface{}) (*RetInfo, error) {
 	//获取f
-	f, err := c.f(id, 0)
+	f, err := c.f(id, n)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	//发起调用
-	err = c.call(&CallInfo{
+	chanRet := c.chanSyncRet
+	err = c.callContext(ctx, &CallInfo{
+		id:      id,
+		n:       n,
 		f:       f,
 		args:    args,
-		chanRet: c.chanSyncRet, //同步返回管道
-	}, true)
+		chanRet: chanRet, //同步返回管道
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	//读取结果
-	ri := <-c.chanSyncRet
-	//返回错误字段，代表是否有错
-	return ri.err
+	select {
+	case ri := <-chanRet:
+		return ri, nil
+	case <-ctx.Done():
+		//放弃等待，rpc服务器迟到的返回值会写入旧的管道(容量为1，不会阻塞rpc服务器)
+		//换一个新的同步返回管道，保证下一次调用不会读到这次的返回值
+		c.chanSyncRet = make(chan *RetInfo, 1)
+		return nil, ctxErr(ctx)
+	}
+}
+
+//调用0
+//适合参数是切片，值任意。无返回值
+//call0 call1 calln 可以将0 1 n记作0个返回值，1个返回值，n个返回值
+func (c *Client) Call0(id interface{}, args ...interface{}) error {
+	return c.Call0Context(context.Background(), id, args...)
 }
 
 //调用1
 //适合参数是切片，值任意。返回值为一个任意值
 func (c *Client) Call1(id interface{}, args ...interface{}) (interface{}, error) {
-	//读取f
-	f, err := c.f(id, 1)
+	return c.Call1Context(context.Background(), id, args...)
+}
+
+//调用N
+//适合参数是切片，返回值也是切片，值均为任意
+func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, error) {
+	return c.CallNContext(context.Background(), id, args...)
+}
+
+//带context的调用0，ctx被取消或者截止时间到达时不再等待返回
+//放弃等待的调用仍然可能在rpc服务器上执行
+func (c *Client) Call0Context(ctx context.Context, id interface{}, args ...interface{}) error {
+	ri, err := c.syncCall(ctx, id, 0, args)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	//发起调用
-	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
-	}, true)
+	//返回错误字段，代表是否有错
+	return ri.err
+}
+
+//带context的调用1
+func (c *Client) Call1Context(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	ri, err := c.syncCall(ctx, id, 1, args)
 	if err != nil {
 		return nil, err
 	}
-	//读取结果
-	ri := <-c.chanSyncRet
 	//返回返回值字段和错误字段
 	return ri.ret, ri.err
 }
 
-//调用N
-//适合参数是切片，返回值也是切片，值均为任意
-func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, error) {
-	//读取f
-	f, err := c.f(id, 2)
+//带context的调用N
+func (c *Client) CallNContext(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	ri, err := c.syncCall(ctx, id, 2, args)
 	if err != nil {
 		return nil, err
 	}
-	//发起调用
-	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
-	}, true)
-	if err != nil {
-		return nil, err
-	}
-	//读取结果
-	ri := <-c.chanSyncRet
-	//返回返回值字段（先转化类型）和错误字段
-	return ri.ret.([]interface{}), ri.err
+	//返回返回值字段（先转化类型，出错时为nil）和错误字段
+	ret, _ := ri.ret.([]interface{})
+	return ret, ri.err
 }
 
 //发起异步调用(内部的)
@@ -295,6 +396,8 @@ func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n
 	}
 	//发起调用
 	err = c.call(&CallInfo{
+		id:      id,
+		n:       n,
 		f:       f,
 		args:    args,
 		chanRet: c.ChanAsynRet, //异步返回管道
@@ -345,6 +448,11 @@ func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
 	}
 }
 
+//异步调用的回调函数，用于统计和日志
+func (ri *RetInfo) Callback() interface{} {
+	return ri.cb
+}
+
 //执行回调
 func (c *Client) Cb(ri *RetInfo) {
 	switch ri.cb.(type) { //判断回调类型
@@ -353,7 +461,8 @@ func (c *Client) Cb(ri *RetInfo) {
 	case func(interface{}, error): //一个返回值，一个错误
 		ri.cb.(func(interface{}, error))(ri.ret, ri.err) //执行回调
 	case func([]interface{}, error): //多个返回值，一个错误
-		ri.cb.(func([]interface{}, error))(ri.ret.([]interface{}), ri.err) //执行回调
+		ret, _ := ri.ret.([]interface{})                //出错时ret为nil
+		ri.cb.(func([]interface{}, error))(ret, ri.err) //执行回调
 	default:
 		panic("bug")
 	}
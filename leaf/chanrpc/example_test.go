@@ -1,9 +1,12 @@
 package chanrpc_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/name5566/leaf/chanrpc"
 	"sync"
+	"time"
 )
 
 func Example() {
@@ -132,3 +135,152 @@ func Example() {
 	// 1 2 3
 	// 3
 }
+
+func ExampleProc2() {
+	s := chanrpc.NewServer(10)
+
+	// 类型化的RPC定义，参数类型在编译期检查
+	login := chanrpc.Proc2[string, int]{ID: "login"}
+	add := chanrpc.Func2[int, int, int]{ID: "add"}
+
+	login.Register(s, func(name string, level int) {
+		fmt.Println(name, level)
+	})
+	add.Register(s, func(n1 int, n2 int) int {
+		return n1 + n2
+	})
+	// 字符串id的注册方式可以共存
+	s.Register("f0", func(args []interface{}) {})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	// goroutine 1
+	go func() {
+		c := s.Open(10)
+
+		err := login.Call(c, "leaf", 1)
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		r, err := add.Call(c, 1, 2)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Println(r)
+		}
+
+		add.AsynCall(c, 3, 4, func(r int, err error) {
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println(r)
+			}
+		})
+		c.Cb(<-c.ChanAsynRet)
+
+		// 类型化的定义也可以用非类型化的方式调用
+		ra, err := c.Call1("add", 5, 6)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Println(ra)
+		}
+
+		wg.Done()
+	}()
+
+	// goroutine 2
+	go func() {
+		for {
+			err := s.Exec(<-s.ChanCall)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	// Output:
+	// leaf 1
+	// 3
+	// 7
+	// 11
+}
+
+func ExampleErrFunc2() {
+	s := chanrpc.NewServer(10)
+
+	// 处理函数返回的错误就是调用返回的错误
+	login := chanrpc.ErrFunc2[string, string]{ID: "login"}
+	login.Register(s, func(name string, password string) error {
+		if password != "leaf" {
+			return errors.New("wrong password")
+		}
+		return nil
+	})
+
+	go func() {
+		for {
+			s.Exec(<-s.ChanCall)
+		}
+	}()
+
+	c := s.Open(10)
+	fmt.Println(login.Call(c, "leaf", "leaf"))
+	fmt.Println(login.Call(c, "leaf", "123"))
+
+	login.AsynCall(c, "leaf", "456", func(err error) {
+		fmt.Println(err)
+	})
+	c.Cb(<-c.ChanAsynRet)
+
+	// Output:
+	// <nil>
+	// wrong password
+	// wrong password
+}
+
+func ExampleClient_Call0Context() {
+	s := chanrpc.NewServer(10)
+
+	release := make(chan bool)
+	s.Register("slow", func(args []interface{}) {
+		<-release //模拟一个阻塞的模块
+	})
+	s.Register("f1", func(args []interface{}) interface{} {
+		return 1
+	})
+
+	// goroutine 1
+	go func() {
+		for {
+			err := s.Exec(<-s.ChanCall)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+	}()
+
+	c := s.Open(10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := c.Call0Context(ctx, "slow")
+	fmt.Println(err == chanrpc.ErrTimeout)
+
+	// 迟到的返回值不会影响下一次调用
+	release <- true
+	r1, err := c.Call1("f1")
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(r1)
+	}
+
+	// Output:
+	// true
+	// 1
+}
@@ -0,0 +1,458 @@
+package chanrpc
+
+import (
+	"context"
+)
+
+// 类型化的RPC定义，基于泛型对Register/Go/Call0/Call1/AsynCall做了一层封装
+// 参数和返回值的类型在编译期检查，底层仍然注册为func([]interface{})或func([]interface{}) interface{}，
+// 因此和字符串id的注册方式可以共存于同一个Server，也可以用Call0/Call1等非类型化的方式调用
+//
+// ProcN：N个参数，无返回值，对应Call0
+// FuncN：N个参数，一个返回值R，对应Call1
+// ErrFuncN：N个参数，返回error，处理函数返回的错误就是调用返回的错误，对应Call0
+//
+// 示例:
+//	var userLogin = chanrpc.Proc2[gate.Agent, string]{ID: "UserLogin"}
+//	userLogin.Register(s, func(a gate.Agent, accID string) { ... })
+//	userLogin.Go(s, a, accID)
+//
+//	var checkLogin = chanrpc.ErrFunc2[gate.Agent, string]{ID: "CheckLogin"}
+//	checkLogin.Register(s, func(a gate.Agent, accID string) error { ... })
+//	err := checkLogin.Call(c, a, accID) //处理函数返回的错误或者调用本身的错误
+
+//取出第i个参数并转换为T类型，nil转换为T的零值(T为接口或指针时)
+func arg[T any](args []interface{}, i int) (v T) {
+	if args[i] != nil {
+		v = args[i].(T)
+	}
+	return
+}
+
+//转换返回值为R类型
+func result[R any](ret interface{}) (r R) {
+	if ret != nil {
+		r = ret.(R)
+	}
+	return
+}
+
+//发起类型化的同步调用，无返回值
+func call0(ctx context.Context, c *Client, id interface{}, args ...interface{}) error {
+	return c.Call0Context(ctx, id, args...)
+}
+
+//发起类型化的同步调用，一个返回值
+func call1[R any](ctx context.Context, c *Client, id interface{}, args ...interface{}) (R, error) {
+	ret, err := c.Call1Context(ctx, id, args...)
+	if err != nil {
+		var zero R
+		return zero, err
+	}
+	return result[R](ret), nil
+}
+
+//发起类型化的异步调用，无返回值
+func asynCall0(c *Client, id interface{}, cb func(error), args ...interface{}) {
+	c.AsynCall(id, append(args, cb)...)
+}
+
+//发起类型化的异步调用，一个返回值
+func asynCall1[R any](c *Client, id interface{}, cb func(R, error), args ...interface{}) {
+	c.AsynCall(id, append(args, func(ret interface{}, err error) {
+		if err != nil {
+			var zero R
+			cb(zero, err)
+			return
+		}
+		cb(result[R](ret), nil)
+	})...)
+}
+
+// Proc0 无参数，无返回值
+type Proc0 struct {
+	ID interface{} //函数id
+}
+
+// you must call the function before calling Open and Go
+func (p Proc0) Register(s *Server, f func()) {
+	s.Register(p.ID, func(args []interface{}) {
+		f()
+	})
+}
+
+// goroutine safe
+func (p Proc0) Go(s *Server) {
+	s.Go(p.ID)
+}
+
+//同步调用
+func (p Proc0) Call(c *Client) error {
+	return call0(context.Background(), c, p.ID)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p Proc0) CallContext(ctx context.Context, c *Client) error {
+	return call0(ctx, c, p.ID)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p Proc0) AsynCall(c *Client, cb func(error)) {
+	asynCall0(c, p.ID, cb)
+}
+
+// Proc1 一个参数，无返回值
+type Proc1[A1 any] struct {
+	ID interface{}
+}
+
+// you must call the function before calling Open and Go
+func (p Proc1[A1]) Register(s *Server, f func(A1)) {
+	s.Register(p.ID, func(args []interface{}) {
+		f(arg[A1](args, 0))
+	})
+}
+
+// goroutine safe
+func (p Proc1[A1]) Go(s *Server, a1 A1) {
+	s.Go(p.ID, a1)
+}
+
+//同步调用
+func (p Proc1[A1]) Call(c *Client, a1 A1) error {
+	return call0(context.Background(), c, p.ID, a1)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p Proc1[A1]) CallContext(ctx context.Context, c *Client, a1 A1) error {
+	return call0(ctx, c, p.ID, a1)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p Proc1[A1]) AsynCall(c *Client, a1 A1, cb func(error)) {
+	asynCall0(c, p.ID, cb, a1)
+}
+
+// Proc2 两个参数，无返回值
+type Proc2[A1, A2 any] struct {
+	ID interface{}
+}
+
+// you must call the function before calling Open and Go
+func (p Proc2[A1, A2]) Register(s *Server, f func(A1, A2)) {
+	s.Register(p.ID, func(args []interface{}) {
+		f(arg[A1](args, 0), arg[A2](args, 1))
+	})
+}
+
+// goroutine safe
+func (p Proc2[A1, A2]) Go(s *Server, a1 A1, a2 A2) {
+	s.Go(p.ID, a1, a2)
+}
+
+//同步调用
+func (p Proc2[A1, A2]) Call(c *Client, a1 A1, a2 A2) error {
+	return call0(context.Background(), c, p.ID, a1, a2)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p Proc2[A1, A2]) CallContext(ctx context.Context, c *Client, a1 A1, a2 A2) error {
+	return call0(ctx, c, p.ID, a1, a2)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p Proc2[A1, A2]) AsynCall(c *Client, a1 A1, a2 A2, cb func(error)) {
+	asynCall0(c, p.ID, cb, a1, a2)
+}
+
+// Proc3 三个参数，无返回值
+type Proc3[A1, A2, A3 any] struct {
+	ID interface{}
+}
+
+// you must call the function before calling Open and Go
+func (p Proc3[A1, A2, A3]) Register(s *Server, f func(A1, A2, A3)) {
+	s.Register(p.ID, func(args []interface{}) {
+		f(arg[A1](args, 0), arg[A2](args, 1), arg[A3](args, 2))
+	})
+}
+
+// goroutine safe
+func (p Proc3[A1, A2, A3]) Go(s *Server, a1 A1, a2 A2, a3 A3) {
+	s.Go(p.ID, a1, a2, a3)
+}
+
+//同步调用
+func (p Proc3[A1, A2, A3]) Call(c *Client, a1 A1, a2 A2, a3 A3) error {
+	return call0(context.Background(), c, p.ID, a1, a2, a3)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p Proc3[A1, A2, A3]) CallContext(ctx context.Context, c *Client, a1 A1, a2 A2, a3 A3) error {
+	return call0(ctx, c, p.ID, a1, a2, a3)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p Proc3[A1, A2, A3]) AsynCall(c *Client, a1 A1, a2 A2, a3 A3, cb func(error)) {
+	asynCall0(c, p.ID, cb, a1, a2, a3)
+}
+
+// Func0 无参数，一个返回值
+type Func0[R any] struct {
+	ID interface{}
+}
+
+// you must call the function before calling Open and Go
+func (p Func0[R]) Register(s *Server, f func() R) {
+	s.Register(p.ID, func(args []interface{}) interface{} {
+		return f()
+	})
+}
+
+// goroutine safe
+func (p Func0[R]) Go(s *Server) {
+	s.Go(p.ID)
+}
+
+//同步调用
+func (p Func0[R]) Call(c *Client) (R, error) {
+	return call1[R](context.Background(), c, p.ID)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p Func0[R]) CallContext(ctx context.Context, c *Client) (R, error) {
+	return call1[R](ctx, c, p.ID)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p Func0[R]) AsynCall(c *Client, cb func(R, error)) {
+	asynCall1(c, p.ID, cb)
+}
+
+// Func1 一个参数，一个返回值
+type Func1[A1, R any] struct {
+	ID interface{}
+}
+
+// you must call the function before calling Open and Go
+func (p Func1[A1, R]) Register(s *Server, f func(A1) R) {
+	s.Register(p.ID, func(args []interface{}) interface{} {
+		return f(arg[A1](args, 0))
+	})
+}
+
+// goroutine safe
+func (p Func1[A1, R]) Go(s *Server, a1 A1) {
+	s.Go(p.ID, a1)
+}
+
+//同步调用
+func (p Func1[A1, R]) Call(c *Client, a1 A1) (R, error) {
+	return call1[R](context.Background(), c, p.ID, a1)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p Func1[A1, R]) CallContext(ctx context.Context, c *Client, a1 A1) (R, error) {
+	return call1[R](ctx, c, p.ID, a1)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p Func1[A1, R]) AsynCall(c *Client, a1 A1, cb func(R, error)) {
+	asynCall1(c, p.ID, cb, a1)
+}
+
+// Func2 两个参数，一个返回值
+type Func2[A1, A2, R any] struct {
+	ID interface{}
+}
+
+// you must call the function before calling Open and Go
+func (p Func2[A1, A2, R]) Register(s *Server, f func(A1, A2) R) {
+	s.Register(p.ID, func(args []interface{}) interface{} {
+		return f(arg[A1](args, 0), arg[A2](args, 1))
+	})
+}
+
+// goroutine safe
+func (p Func2[A1, A2, R]) Go(s *Server, a1 A1, a2 A2) {
+	s.Go(p.ID, a1, a2)
+}
+
+//同步调用
+func (p Func2[A1, A2, R]) Call(c *Client, a1 A1, a2 A2) (R, error) {
+	return call1[R](context.Background(), c, p.ID, a1, a2)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p Func2[A1, A2, R]) CallContext(ctx context.Context, c *Client, a1 A1, a2 A2) (R, error) {
+	return call1[R](ctx, c, p.ID, a1, a2)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p Func2[A1, A2, R]) AsynCall(c *Client, a1 A1, a2 A2, cb func(R, error)) {
+	asynCall1(c, p.ID, cb, a1, a2)
+}
+
+// Func3 三个参数，一个返回值
+type Func3[A1, A2, A3, R any] struct {
+	ID interface{}
+}
+
+// you must call the function before calling Open and Go
+func (p Func3[A1, A2, A3, R]) Register(s *Server, f func(A1, A2, A3) R) {
+	s.Register(p.ID, func(args []interface{}) interface{} {
+		return f(arg[A1](args, 0), arg[A2](args, 1), arg[A3](args, 2))
+	})
+}
+
+// goroutine safe
+func (p Func3[A1, A2, A3, R]) Go(s *Server, a1 A1, a2 A2, a3 A3) {
+	s.Go(p.ID, a1, a2, a3)
+}
+
+//同步调用
+func (p Func3[A1, A2, A3, R]) Call(c *Client, a1 A1, a2 A2, a3 A3) (R, error) {
+	return call1[R](context.Background(), c, p.ID, a1, a2, a3)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p Func3[A1, A2, A3, R]) CallContext(ctx context.Context, c *Client, a1 A1, a2 A2, a3 A3) (R, error) {
+	return call1[R](ctx, c, p.ID, a1, a2, a3)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p Func3[A1, A2, A3, R]) AsynCall(c *Client, a1 A1, a2 A2, a3 A3, cb func(R, error)) {
+	asynCall1(c, p.ID, cb, a1, a2, a3)
+}
+
+// ErrFunc0 无参数，返回error
+type ErrFunc0 struct {
+	ID interface{} //函数id
+}
+
+// you must call the function before calling Open and Go
+func (p ErrFunc0) Register(s *Server, f func() error) {
+	s.Register(p.ID, func(args []interface{}) error {
+		return f()
+	})
+}
+
+// goroutine safe
+//处理函数返回的错误被忽略
+func (p ErrFunc0) Go(s *Server) {
+	s.Go(p.ID)
+}
+
+//同步调用，返回处理函数返回的错误
+func (p ErrFunc0) Call(c *Client) error {
+	return call0(context.Background(), c, p.ID)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p ErrFunc0) CallContext(ctx context.Context, c *Client) error {
+	return call0(ctx, c, p.ID)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p ErrFunc0) AsynCall(c *Client, cb func(error)) {
+	asynCall0(c, p.ID, cb)
+}
+
+// ErrFunc1 一个参数，返回error
+type ErrFunc1[A1 any] struct {
+	ID interface{}
+}
+
+// you must call the function before calling Open and Go
+func (p ErrFunc1[A1]) Register(s *Server, f func(A1) error) {
+	s.Register(p.ID, func(args []interface{}) error {
+		return f(arg[A1](args, 0))
+	})
+}
+
+// goroutine safe
+//处理函数返回的错误被忽略
+func (p ErrFunc1[A1]) Go(s *Server, a1 A1) {
+	s.Go(p.ID, a1)
+}
+
+//同步调用，返回处理函数返回的错误
+func (p ErrFunc1[A1]) Call(c *Client, a1 A1) error {
+	return call0(context.Background(), c, p.ID, a1)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p ErrFunc1[A1]) CallContext(ctx context.Context, c *Client, a1 A1) error {
+	return call0(ctx, c, p.ID, a1)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p ErrFunc1[A1]) AsynCall(c *Client, a1 A1, cb func(error)) {
+	asynCall0(c, p.ID, cb, a1)
+}
+
+// ErrFunc2 两个参数，返回error
+type ErrFunc2[A1, A2 any] struct {
+	ID interface{}
+}
+
+// you must call the function before calling Open and Go
+func (p ErrFunc2[A1, A2]) Register(s *Server, f func(A1, A2) error) {
+	s.Register(p.ID, func(args []interface{}) error {
+		return f(arg[A1](args, 0), arg[A2](args, 1))
+	})
+}
+
+// goroutine safe
+//处理函数返回的错误被忽略
+func (p ErrFunc2[A1, A2]) Go(s *Server, a1 A1, a2 A2) {
+	s.Go(p.ID, a1, a2)
+}
+
+//同步调用，返回处理函数返回的错误
+func (p ErrFunc2[A1, A2]) Call(c *Client, a1 A1, a2 A2) error {
+	return call0(context.Background(), c, p.ID, a1, a2)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p ErrFunc2[A1, A2]) CallContext(ctx context.Context, c *Client, a1 A1, a2 A2) error {
+	return call0(ctx, c, p.ID, a1, a2)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p ErrFunc2[A1, A2]) AsynCall(c *Client, a1 A1, a2 A2, cb func(error)) {
+	asynCall0(c, p.ID, cb, a1, a2)
+}
+
+// ErrFunc3 三个参数，返回error
+type ErrFunc3[A1, A2, A3 any] struct {
+	ID interface{}
+}
+
+// you must call the function before calling Open and Go
+func (p ErrFunc3[A1, A2, A3]) Register(s *Server, f func(A1, A2, A3) error) {
+	s.Register(p.ID, func(args []interface{}) error {
+		return f(arg[A1](args, 0), arg[A2](args, 1), arg[A3](args, 2))
+	})
+}
+
+// goroutine safe
+//处理函数返回的错误被忽略
+func (p ErrFunc3[A1, A2, A3]) Go(s *Server, a1 A1, a2 A2, a3 A3) {
+	s.Go(p.ID, a1, a2, a3)
+}
+
+//同步调用，返回处理函数返回的错误
+func (p ErrFunc3[A1, A2, A3]) Call(c *Client, a1 A1, a2 A2, a3 A3) error {
+	return call0(context.Background(), c, p.ID, a1, a2, a3)
+}
+
+//带context的同步调用，超时返回ErrTimeout
+func (p ErrFunc3[A1, A2, A3]) CallContext(ctx context.Context, c *Client, a1 A1, a2 A2, a3 A3) error {
+	return call0(ctx, c, p.ID, a1, a2, a3)
+}
+
+//异步调用，需要c.Cb(<-c.ChanAsynRet)执行回调
+func (p ErrFunc3[A1, A2, A3]) AsynCall(c *Client, a1 A1, a2 A2, a3 A3, cb func(error)) {
+	asynCall0(c, p.ID, cb, a1, a2, a3)
+}
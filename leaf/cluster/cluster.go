@@ -0,0 +1,175 @@
+package cluster
+
+import (
+	"errors"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"math"
+	"sync"
+)
+
+var (
+	server  *network.TCPServer             //接受其它节点连接的服务器
+	servers = map[string]*chanrpc.Server{} //导出的chanrpc服务器，name->server
+
+	mutexNodes sync.Mutex
+	nodes      = map[string]*node{} //连接的远程节点，addr->node
+)
+
+// you must call the function before calling Init
+// goroutine not safe
+//导出本地的chanrpc服务器，其它节点可以通过NewRemote(addr, name)调用
+func Export(name string, s *chanrpc.Server) {
+	if _, ok := servers[name]; ok {
+		log.Fatal("chanrpc server %v is already exported", name)
+	}
+	servers[name] = s
+}
+
+//初始化，启动集群服务器和心跳
+func Init() {
+	startMembership()
+
+	if conf.ListenAddr != "" {
+		server = new(network.TCPServer)
+		server.Addr = conf.ListenAddr
+		server.MaxConnNum = int(math.MaxInt32)
+		server.PendingWriteNum = conf.PendingWriteNum
+		server.LenMsgLen = 4
+		server.MaxMsgLen = maxMsgLen
+		server.NewAgent = newServerAgent
+
+		server.Start()
+	}
+}
+
+//销毁，关闭集群服务器和所有远程节点连接
+func Destroy() {
+	stopMembership()
+
+	if server != nil {
+		server.Close()
+		server = nil
+	}
+
+	mutexNodes.Lock()
+	for _, n := range nodes {
+		n.close()
+	}
+	nodes = map[string]*node{}
+	mutexNodes.Unlock()
+
+	mutexMembers.Lock()
+	members = map[string]*member{}
+	mutexMembers.Unlock()
+}
+
+//服务器端代理，执行其它节点发来的调用
+type serverAgent struct {
+	conn *network.TCPConn
+}
+
+func newServerAgent(conn *network.TCPConn) network.Agent {
+	a := new(serverAgent)
+	a.conn = conn
+	return a
+}
+
+func (a *serverAgent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			log.Debug("read message error: %v", err)
+			break
+		}
+
+		m, err := decode(data)
+		if err != nil {
+			log.Error("decode message error: %v", err)
+			break
+		}
+
+		if m.Heartbeat != nil { //心跳，更新成员表并回应本节点信息
+			updateMember(m.Heartbeat)
+			a.heartbeat()
+			continue
+		}
+
+		s := servers[m.Name]
+		if s == nil {
+			a.ret(m, nil, errors.New("chanrpc server "+m.Name+" not exported"))
+			continue
+		}
+
+		if m.NumRet < 0 { //Go调用，不需要返回
+			s.Go(m.ID, m.Args...)
+			continue
+		}
+
+		go a.exec(s, m) //同步调用会阻塞，不能阻塞读取
+	}
+}
+
+//在本地chanrpc服务器上执行调用并返回结果
+func (a *serverAgent) exec(s *chanrpc.Server, m *message) {
+	c := s.Open(0)
+
+	var ret interface{}
+	var err error
+	switch m.NumRet {
+	case 0:
+		err = c.Call0(m.ID, m.Args...)
+	case 1:
+		ret, err = c.Call1(m.ID, m.Args...)
+	default:
+		var rets []interface{}
+		rets, err = c.CallN(m.ID, m.Args...)
+		if rets != nil {
+			ret = rets
+		}
+	}
+
+	a.ret(m, ret, err)
+}
+
+//发送返回值
+func (a *serverAgent) ret(m *message, ret interface{}, err error) {
+	if m.NumRet < 0 {
+		return
+	}
+
+	r := &message{Seq: m.Seq, Ret: ret}
+	if err != nil {
+		r.Err = err.Error()
+	}
+	data, e := encode(r)
+	if e != nil { //返回值无法编码，只返回错误
+		log.Error("encode message error: %v", e)
+		data, e = encode(&message{Seq: m.Seq, Err: e.Error()})
+		if e != nil {
+			return
+		}
+	}
+	err = a.conn.WriteMsg(data)
+	if err != nil {
+		log.Error("write message error: %v", err)
+	}
+}
+
+//回应心跳
+func (a *serverAgent) heartbeat() {
+	self := getSelf()
+	if self == nil {
+		return
+	}
+	data, err := encode(&message{Heartbeat: self})
+	if err != nil {
+		log.Error("encode message error: %v", err)
+		return
+	}
+	a.conn.WriteMsg(data)
+}
+
+func (a *serverAgent) OnClose() {}
@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+)
+
+//服务发现接口，用于获取其它节点的地址
+//conf.ConnAddrs总是会被使用，Discovery提供额外的节点
+type Discovery interface {
+	// must goroutine safe
+	//登记本节点，Init时调用一次
+	Register(info *NodeInfo) error
+	// must goroutine safe
+	//返回当前已知的节点地址，每次心跳时调用
+	Addrs() ([]string, error)
+}
+
+//静态地址列表
+type StaticDiscovery []string
+
+func (d StaticDiscovery) Register(info *NodeInfo) error {
+	return nil
+}
+
+func (d StaticDiscovery) Addrs() ([]string, error) {
+	return d, nil
+}
+
+//基于文件的服务发现，每行一个节点地址，用于测试和单机部署
+//Register把本节点的地址追加到文件中，多个进程共享同一个文件即可互相发现
+type FileDiscovery struct {
+	Path  string //文件路径
+	mutex sync.Mutex
+}
+
+func (d *FileDiscovery) Register(info *NodeInfo) error {
+	if info.Addr == "" { //不接受连接的节点不需要登记
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	addrs, err := d.read()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, addr := range addrs {
+		if addr == info.Addr {
+			return nil
+		}
+	}
+
+	f, err := os.OpenFile(d.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(info.Addr + "\n")
+	return err
+}
+
+func (d *FileDiscovery) Addrs() ([]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	addrs, err := d.read()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return addrs, err
+}
+
+//读取所有地址，忽略空行和#开头的注释
+func (d *FileDiscovery) read() ([]string, error) {
+	f, err := os.Open(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var addrs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, scanner.Err()
+}
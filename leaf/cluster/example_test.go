@@ -0,0 +1,95 @@
+package cluster_test
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/cluster"
+	"github.com/name5566/leaf/conf"
+	"os"
+	"time"
+)
+
+func Example() {
+	// node 1
+	//导出chanrpc服务器，在自己的goroutine中执行调用
+	s := chanrpc.NewServer(10)
+	s.Register("add", func(args []interface{}) interface{} {
+		return args[0].(int) + args[1].(int)
+	})
+	s.Register("fn", func(args []interface{}) []interface{} {
+		return []interface{}{1, "2", 3.0}
+	})
+	s.Register("panic", func(args []interface{}) {
+		panic("remote panic")
+	})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+
+	cluster.Export("game", s)
+	conf.ListenAddr = "127.0.0.1:3564"
+	cluster.Init()
+	defer cluster.Destroy()
+
+	// node 2
+	//远程chanrpc服务器的本地代理，用法和本地的chanrpc服务器相同
+	game := cluster.NewRemote("127.0.0.1:3564", "game", 10)
+	c := game.Open(10)
+
+	//等待连接建立
+	for {
+		if _, err := c.Call1("add", 0, 0); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r, err := c.Call1("add", 1, 2)
+	fmt.Println(r, err)
+
+	rn, err := c.CallN("fn")
+	fmt.Println(rn, err)
+
+	err = c.Call0("panic")
+	fmt.Println(err)
+
+	c.AsynCall("add", 3, 4, func(ret interface{}, err error) {
+		fmt.Println(ret, err)
+	})
+	c.Cb(<-c.ChanAsynRet)
+
+	// Output:
+	// 3 <nil>
+	// [1 2 3] <nil>
+	// remote panic
+	// 7 <nil>
+}
+
+func ExampleLookup() {
+	s := chanrpc.NewServer(10)
+	cluster.Export("game1", s)
+
+	//每个节点启动时把自己的地址登记到文件中，并从文件中发现其它节点
+	f, err := os.CreateTemp("", "nodes")
+	if err != nil {
+		return
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	cluster.SetDiscovery(&cluster.FileDiscovery{Path: f.Name()})
+
+	conf.NodeName = "node1"
+	conf.ListenAddr = "127.0.0.1:3565"
+	cluster.Init()
+	defer cluster.Destroy()
+
+	//查找game分片1所在的节点
+	for _, info := range cluster.Lookup("game1") {
+		fmt.Println(info.Name, info.Addr)
+	}
+
+	// Output:
+	// node1 127.0.0.1:3565
+}
@@ -0,0 +1,288 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+//节点信息，通过心跳在节点间传递
+type NodeInfo struct {
+	Name     string   //节点名字
+	Addr     string   //集群监听地址，为空表示不接受连接
+	Services []string //导出的chanrpc服务器名字
+}
+
+//成员
+type member struct {
+	info     NodeInfo
+	lastSeen time.Time //最后一次收到心跳的时间
+	alive    bool      //是否存活
+}
+
+var (
+	mutexSelf sync.Mutex //保护self和discovery
+	self      *NodeInfo  //本节点信息，Init时创建，Destroy时重置
+	discovery Discovery  //服务发现，Destroy时重置
+
+	closeSig chan bool      //关闭心跳，只在调用Init和Destroy的goroutine中使用
+	wg       sync.WaitGroup //等待心跳goroutine退出
+
+	mutexMembers sync.RWMutex
+	members      = map[string]*member{} //成员表，name->member
+)
+
+// you must call the function before calling Init
+//设置服务发现
+func SetDiscovery(d Discovery) {
+	mutexSelf.Lock()
+	defer mutexSelf.Unlock()
+	discovery = d
+}
+
+// goroutine safe
+//本节点信息，没有Init时为nil
+func getSelf() *NodeInfo {
+	mutexSelf.Lock()
+	defer mutexSelf.Unlock()
+	return self
+}
+
+//创建本节点信息
+func newSelf() *NodeInfo {
+	info := new(NodeInfo)
+	info.Name = conf.NodeName
+	if info.Name == "" {
+		info.Name = conf.ListenAddr
+	}
+	info.Addr = conf.ListenAddr
+	for name := range servers {
+		info.Services = append(info.Services, name)
+	}
+	sort.Strings(info.Services)
+	return info
+}
+
+//启动心跳
+func startMembership() {
+	if conf.HeartbeatInterval <= 0 {
+		conf.HeartbeatInterval = 3 * time.Second
+	}
+	if conf.HeartbeatTimeout <= 0 {
+		conf.HeartbeatTimeout = 10 * time.Second
+	}
+
+	info := newSelf()
+	mutexSelf.Lock()
+	self = info
+	d := discovery
+	mutexSelf.Unlock()
+	updateMember(info) //本节点总是在成员表中
+
+	if d != nil {
+		err := d.Register(info)
+		if err != nil {
+			log.Error("register node %v error: %v", info.Name, err)
+		}
+	}
+
+	closeSig = make(chan bool)
+	wg.Add(1)
+	go func(closeSig chan bool) {
+		defer wg.Done()
+		ticker := time.NewTicker(conf.HeartbeatInterval)
+		defer ticker.Stop()
+
+		discovered := heartbeat(info, d, nil)
+		for {
+			select {
+			case <-closeSig:
+				return
+			case <-ticker.C:
+				discovered = heartbeat(info, d, discovered)
+				expireMembers(info)
+			}
+		}
+	}(closeSig)
+}
+
+//停止心跳，等待心跳goroutine退出
+func stopMembership() {
+	if closeSig != nil {
+		close(closeSig)
+		closeSig = nil
+	}
+	wg.Wait()
+
+	mutexSelf.Lock()
+	self = nil
+	discovery = nil
+	mutexSelf.Unlock()
+}
+
+//向所有已知节点发送心跳，返回服务发现这次返回的地址
+//discovered为上次返回的地址，不再出现的地址会断开到该节点的连接
+func heartbeat(info *NodeInfo, d Discovery, discovered map[string]bool) map[string]bool {
+	addrs := append([]string{}, conf.ConnAddrs...)
+	current := make(map[string]bool)
+	if d != nil {
+		a, err := d.Addrs()
+		if err != nil {
+			log.Error("discovery error: %v", err)
+			current = discovered //出错时保留上次的结果
+		} else {
+			for _, addr := range a {
+				current[addr] = true
+			}
+		}
+		addrs = append(addrs, a...)
+	}
+	for addr := range discovered {
+		if !current[addr] {
+			removeNode(addr)
+		}
+	}
+
+	var probes sync.WaitGroup
+	for _, addr := range addrs {
+		if addr == info.Addr {
+			continue
+		}
+		if n := findNode(addr); n != nil && n.heartbeat(info) { //有远程调用的节点使用已有的连接
+			continue
+		}
+		probes.Add(1)
+		go func(addr string) {
+			defer probes.Done()
+			probe(addr, info, conf.HeartbeatInterval)
+		}(addr)
+	}
+	probes.Wait()
+	return current
+}
+
+//用短连接向addr发送一次心跳并读取回应，不会为没有远程调用的节点保持连接
+//消息格式见msg.go，len为大端
+func probe(addr string, info *NodeInfo, timeout time.Duration) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		log.Debug("heartbeat %v error: %v", addr, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	data, err := encode(&message{Heartbeat: info})
+	if err != nil {
+		log.Error("encode message error: %v", err)
+		return
+	}
+	msg := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(msg, uint32(len(data)))
+	copy(msg[4:], data)
+	if _, err := conn.Write(msg); err != nil {
+		log.Debug("heartbeat %v error: %v", addr, err)
+		return
+	}
+
+	var bufLen [4]byte
+	if _, err := io.ReadFull(conn, bufLen[:]); err != nil {
+		log.Debug("heartbeat %v error: %v", addr, err)
+		return
+	}
+	msgLen := binary.BigEndian.Uint32(bufLen[:])
+	if msgLen > maxMsgLen {
+		log.Debug("heartbeat %v error: message too long", addr)
+		return
+	}
+	data = make([]byte, msgLen)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		log.Debug("heartbeat %v error: %v", addr, err)
+		return
+	}
+	m, err := decode(data)
+	if err != nil {
+		log.Error("decode message error: %v", err)
+		return
+	}
+	if m.Heartbeat != nil {
+		updateMember(m.Heartbeat)
+	}
+}
+
+//收到心跳，更新成员表
+func updateMember(info *NodeInfo) {
+	if info.Name == "" {
+		return
+	}
+
+	mutexMembers.Lock()
+	defer mutexMembers.Unlock()
+
+	m := members[info.Name]
+	if m == nil {
+		m = new(member)
+		members[info.Name] = m
+	}
+	if !m.alive {
+		log.Release("node %v (%v) joined, services: %v", info.Name, info.Addr, info.Services)
+	}
+	m.info = *info
+	m.lastSeen = time.Now()
+	m.alive = true
+}
+
+//检查心跳超时的节点
+func expireMembers(self *NodeInfo) {
+	mutexMembers.Lock()
+	defer mutexMembers.Unlock()
+
+	now := time.Now()
+	for name, m := range members {
+		if name == self.Name {
+			continue
+		}
+		if m.alive && now.Sub(m.lastSeen) > conf.HeartbeatTimeout {
+			m.alive = false
+			log.Release("node %v (%v) failed, last seen %v ago", name, m.info.Addr, now.Sub(m.lastSeen))
+		}
+	}
+}
+
+// goroutine safe
+//返回所有存活的节点，按名字排序
+func Members() []NodeInfo {
+	mutexMembers.RLock()
+	defer mutexMembers.RUnlock()
+
+	var infos []NodeInfo
+	for _, m := range members {
+		if m.alive {
+			infos = append(infos, m.info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// goroutine safe
+//查找导出了service的存活节点，按名字排序
+//例如每个game分片导出为"game1"、"game2"，Lookup("game2")即可找到分片2所在的节点，
+//再通过NewRemote(info.Addr, "game2", l)调用
+func Lookup(service string) []NodeInfo {
+	var infos []NodeInfo
+	for _, info := range Members() {
+		for _, s := range info.Services {
+			if s == service {
+				infos = append(infos, info)
+				break
+			}
+		}
+	}
+	return infos
+}
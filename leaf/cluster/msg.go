@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// ------------------------------
+// | len(4 bytes) | gob message |
+// ------------------------------
+//节点间传输的消息，请求和返回共用一个结构
+type message struct {
+	Seq    uint32        //序号，用于匹配请求和返回，Go调用为0
+	Name   string        //chanrpc服务器名字
+	ID     interface{}   //函数id
+	Args   []interface{} //参数
+	NumRet int           //返回值个数，0，1，2(N)，Go调用为-1
+	Ret    interface{}   //返回值
+	Err    string        //错误信息，为空表示没有错误
+
+	Heartbeat *NodeInfo //心跳，携带发送者的节点信息
+}
+
+//单条消息最大长度
+const maxMsgLen = 16 * 1024 * 1024
+
+func init() {
+	gob.Register([]interface{}{}) //CallN的返回值
+}
+
+// you must call the function before calling Init
+//注册在参数和返回值中传输的自定义类型（基础类型不需要注册）
+//函数id和参数会被gob编码，因此只能是可以编码的类型，函数id一般使用字符串
+func RegisterType(value interface{}) {
+	gob.Register(value)
+}
+
+//编码消息
+func encode(m *message) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(m) //每条消息独立编码，连接重建后不需要同步类型信息
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//解码消息
+func decode(data []byte) (*message, error) {
+	m := new(message)
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
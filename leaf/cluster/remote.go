@@ -0,0 +1,306 @@
+package cluster
+
+import (
+	"errors"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"sync"
+	"time"
+)
+
+var (
+	errNotConnected = errors.New("cluster: remote node not connected")
+	errDisconnected = errors.New("cluster: remote node disconnected")
+)
+
+//第一次远程调用时等待连接建立的时间
+const connectTimeout = 3 * time.Second
+
+//远程节点，第一次远程调用时才连接
+type node struct {
+	sync.Mutex
+	addr    string                  //节点地址
+	client  *network.TCPClient      //到节点的连接，断开后自动重连，没有连接过时为nil
+	ready   chan struct{}           //第一次连接建立时关闭
+	conn    *network.TCPConn        //当前连接，未连接时为nil
+	seq     uint32                  //请求序号
+	pending map[uint32]*pendingCall //等待返回的调用
+	proxies []*chanrpc.Server       //该节点上chanrpc服务器的本地代理
+}
+
+//等待返回的调用
+type pendingCall struct {
+	s  *chanrpc.Server
+	ci *chanrpc.CallInfo
+}
+
+// goroutine safe
+//创建远程节点addr上名为name的chanrpc服务器的本地代理，l为代理服务器ChanCall的长度
+//对代理服务器的Go、Open后的Call0/Call1/CallN/AsynCall等调用会被转发到远程节点执行，
+//异步调用的回调仍然通过Client.ChanAsynRet在调用者的goroutine中执行，
+//远程执行时的panic和连接断开都会作为错误返回给调用者
+//第一次远程调用时才连接节点，最多等待connectTimeout
+func NewRemote(addr string, name string, l int) *chanrpc.Server {
+	n := getNode(addr)
+
+	s := chanrpc.NewProxyServer(l)
+	n.Lock()
+	n.proxies = append(n.proxies, s)
+	n.Unlock()
+
+	go func() { //转发调用，ChanCall关闭时结束
+		for ci := range s.ChanCall {
+			n.forward(name, s, ci)
+		}
+	}()
+
+	return s
+}
+
+//获取远程节点，不存在则创建
+func getNode(addr string) *node {
+	mutexNodes.Lock()
+	defer mutexNodes.Unlock()
+
+	n := nodes[addr]
+	if n == nil {
+		n = newNode(addr)
+		nodes[addr] = n
+	}
+	return n
+}
+
+//查找远程节点，不存在时返回nil
+func findNode(addr string) *node {
+	mutexNodes.Lock()
+	defer mutexNodes.Unlock()
+	return nodes[addr]
+}
+
+//节点从服务发现中消失，断开连接，没有本地代理的节点直接删除
+//有本地代理的节点保留，之后的远程调用会重新连接
+func removeNode(addr string) {
+	mutexNodes.Lock()
+	n := nodes[addr]
+	if n == nil {
+		mutexNodes.Unlock()
+		return
+	}
+	n.Lock()
+	idle := len(n.proxies) == 0
+	n.Unlock()
+	if idle {
+		delete(nodes, addr)
+	}
+	mutexNodes.Unlock()
+
+	n.disconnect()
+}
+
+//创建远程节点，不马上连接
+func newNode(addr string) *node {
+	n := new(node)
+	n.addr = addr
+	n.pending = make(map[uint32]*pendingCall)
+	return n
+}
+
+//开始连接，返回第一次连接建立时关闭的channel，调用前需要加锁
+func (n *node) connect() chan struct{} {
+	if n.client != nil {
+		return n.ready
+	}
+
+	ready := make(chan struct{})
+	client := new(network.TCPClient)
+	client.Addr = n.addr
+	client.ConnNum = 1
+	client.ConnectInterval = 3 * time.Second
+	client.PendingWriteNum = conf.PendingWriteNum
+	client.AutoReconnect = true
+	client.LenMsgLen = 4
+	client.MaxMsgLen = maxMsgLen
+	client.NewAgent = func(conn *network.TCPConn) network.Agent {
+		n.Lock()
+		n.conn = conn
+		select {
+		case <-ready:
+		default:
+			close(ready)
+		}
+		n.Unlock()
+		if self := getSelf(); self != nil { //连接建立后马上发送心跳
+			n.heartbeat(self)
+		}
+		return &clientAgent{n: n, conn: conn}
+	}
+	n.client = client
+	n.ready = ready
+
+	client.Start()
+	return ready
+}
+
+//断开连接，之后的远程调用会重新连接
+func (n *node) disconnect() {
+	n.Lock()
+	client := n.client
+	n.client = nil
+	n.ready = nil
+	n.Unlock()
+
+	if client != nil {
+		client.Close()
+	}
+}
+
+//转发一个调用到远程节点
+func (n *node) forward(name string, s *chanrpc.Server, ci *chanrpc.CallInfo) {
+	m := &message{
+		Name:   name,
+		ID:     ci.ID(),
+		Args:   ci.Args(),
+		NumRet: ci.NumRet(),
+	}
+
+	n.Lock()
+	ready := n.connect()
+	conn := n.conn
+	n.Unlock()
+	if conn == nil { //第一次调用时等待连接建立
+		select {
+		case <-ready:
+		case <-time.After(connectTimeout):
+		}
+	}
+
+	n.Lock()
+	conn = n.conn
+	if conn == nil {
+		n.Unlock()
+		n.fail(s, ci, errNotConnected)
+		return
+	}
+	if m.NumRet >= 0 { //需要返回值，记录下来等待返回
+		n.seq++
+		m.Seq = n.seq
+		n.pending[m.Seq] = &pendingCall{s: s, ci: ci}
+	}
+	n.Unlock()
+
+	data, err := encode(m)
+	if err == nil {
+		err = conn.WriteMsg(data)
+	}
+	if err != nil {
+		n.Lock()
+		delete(n.pending, m.Seq)
+		n.Unlock()
+		n.fail(s, ci, err)
+	}
+}
+
+//通过已有的连接发送心跳，没有连接时返回false
+func (n *node) heartbeat(info *NodeInfo) bool {
+	n.Lock()
+	conn := n.conn
+	n.Unlock()
+	if conn == nil {
+		return false
+	}
+
+	data, err := encode(&message{Heartbeat: info})
+	if err != nil {
+		log.Error("encode message error: %v", err)
+		return true
+	}
+	conn.WriteMsg(data)
+	return true
+}
+
+//返回错误，Go调用没有返回值，只记录日志
+func (n *node) fail(s *chanrpc.Server, ci *chanrpc.CallInfo, err error) {
+	if ci.NumRet() < 0 {
+		log.Error("cluster: go %v on %v error: %v", ci.ID(), n.addr, err)
+		return
+	}
+	s.Ret(ci, nil, err)
+}
+
+//收到返回
+func (n *node) onRet(m *message) {
+	n.Lock()
+	p := n.pending[m.Seq]
+	delete(n.pending, m.Seq)
+	n.Unlock()
+	if p == nil {
+		return
+	}
+
+	var err error
+	if m.Err != "" {
+		err = errors.New(m.Err)
+	}
+	p.s.Ret(p.ci, m.Ret, err)
+}
+
+//连接断开，所有等待返回的调用都返回错误
+func (n *node) onClose() {
+	n.Lock()
+	n.conn = nil
+	pending := n.pending
+	n.pending = make(map[uint32]*pendingCall)
+	n.Unlock()
+
+	for _, p := range pending {
+		p.s.Ret(p.ci, nil, errDisconnected)
+	}
+}
+
+//关闭节点连接和本地代理
+func (n *node) close() {
+	n.disconnect()
+
+	n.Lock()
+	proxies := n.proxies
+	n.proxies = nil
+	n.Unlock()
+
+	for _, s := range proxies {
+		s.Close()
+	}
+}
+
+//客户端代理，读取远程节点的返回
+type clientAgent struct {
+	n    *node
+	conn *network.TCPConn
+}
+
+func (a *clientAgent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			log.Debug("read message error: %v", err)
+			break
+		}
+
+		m, err := decode(data)
+		if err != nil {
+			log.Error("decode message error: %v", err)
+			break
+		}
+
+		if m.Heartbeat != nil { //心跳回应
+			updateMember(m.Heartbeat)
+			continue
+		}
+		a.n.onRet(m)
+	}
+}
+
+func (a *clientAgent) OnClose() {
+	a.n.onClose()
+}
@@ -1,12 +1,54 @@
 package conf
 
+import (
+	"time"
+)
+
 var (
 	LenStackBuf = 4096 //保存stack trace buf长度
 
-	LogLevel string //日志级别
-	LogPath  string //日志路径
+	LogLevel  string //日志级别
+	LogPath   string //日志路径
+	LogFormat string //日志格式，"text"(默认)、"json"或者"logfmt"
+
+	// log rotation
+	LogMaxSize    int64         //单个日志文件最大字节数，超过后创建新的文件，为0不按大小切分
+	LogRotateTime string        //按时间切分日志文件，"hour"或者"day"，为空不按时间切分
+	LogMaxBackups int           //保留的旧日志文件个数，为0不限
+	LogMaxAge     time.Duration //旧日志文件保留的时间，为0不限
+	LogCompress   bool          //是否用gzip压缩旧日志文件
 
 	ConsolePort   int               //控制台端口，默认不开启
 	ConsolePrompt string = "Leaf# " //控制台提示符
 	ProfilePath   string            //profile路径
+
+	// console security
+	ConsoleHost     string //控制台监听的主机，默认localhost，开放到外网时应开启TLS和密码
+	ConsoleCertFile string //控制台TLS证书文件，和ConsoleKeyFile都设置时控制台只接受TLS连接
+	ConsoleKeyFile  string //控制台TLS私钥文件
+	ConsolePassword string //控制台密码，登录后的角色为operator，和ConsoleTokens都为空时不需要登录
+
+	// console access control
+	ConsoleTokens       []ConsoleToken    //控制台令牌，每个令牌对应一个用户和角色，登录时和密码一样输入
+	ConsoleCommandRoles map[string]string //命令需要的角色，命令名->"viewer"或者"operator"，默认help和stats为viewer，其它为operator
+	ConsoleIdleTimeout  time.Duration     //控制台连接没有输入的超时，为0不限
+
+	ModuleCloseTimeout time.Duration //等待单个模块关闭的超时，超时后记录日志并跳过该模块，为0时一直等待
+
+	MetricsAddr string //统计数据的HTTP导出地址，路径为/metrics，为空则不开启统计
+
+	// cluster
+	ListenAddr        string        //集群监听地址，为空则不接受其它节点的连接
+	PendingWriteNum   int           //集群连接的发送缓冲区长度
+	NodeName          string        //节点名字，为空则使用ListenAddr
+	ConnAddrs         []string      //静态配置的其它节点地址
+	HeartbeatInterval time.Duration //心跳间隔，默认3秒
+	HeartbeatTimeout  time.Duration //超过该时间没有收到心跳认为节点失效，默认10秒
 )
+
+//控制台令牌
+type ConsoleToken struct {
+	Name  string //用户名，记录在审计日志中
+	Token string //令牌
+	Role  string //角色，"viewer"或者"operator"
+}
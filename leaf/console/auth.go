@@ -0,0 +1,116 @@
+package console
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"time"
+)
+
+//控制台角色
+const (
+	RoleViewer   = "viewer"   //只能执行只读的命令
+	RoleOperator = "operator" //可以执行所有命令
+)
+
+//角色的权限等级，未知的角色没有任何权限
+var roleRanks = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+}
+
+//命令默认需要的角色，不在其中的命令需要RoleOperator，可以用conf.ConsoleCommandRoles覆盖
+var defaultCommandRoles = map[string]string{
+	"help":  RoleViewer,
+	"stats": RoleViewer,
+}
+
+//审计日志，记录登录和执行的命令
+var audit = log.Named("console")
+
+//是否需要登录
+func needLogin() bool {
+	return conf.ConsolePassword != "" || len(conf.ConsoleTokens) > 0
+}
+
+//执行命令需要的角色
+func commandRole(name string) string {
+	if role, ok := conf.ConsoleCommandRoles[name]; ok {
+		return role
+	}
+	if role, ok := defaultCommandRoles[name]; ok {
+		return role
+	}
+	return RoleOperator
+}
+
+//角色是否可以执行命令
+func allowed(role string, name string) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[commandRole(name)]
+}
+
+//根据密码或者令牌登录，返回用户名和角色，失败时user为空
+//ConsolePassword登录的用户名为password，角色为RoleOperator
+func login(secret string) (user string, role string) {
+	b := []byte(secret)
+	if conf.ConsolePassword != "" && subtle.ConstantTimeCompare(b, []byte(conf.ConsolePassword)) == 1 {
+		user, role = "password", RoleOperator
+	}
+	for _, t := range conf.ConsoleTokens { //不提前返回，所有令牌都比较一遍
+		if t.Token != "" && subtle.ConstantTimeCompare(b, []byte(t.Token)) == 1 {
+			user, role = t.Name, t.Role
+		}
+	}
+	return
+}
+
+//验证密码或者令牌，失败时等待一会儿再断开，减慢暴力破解
+func (a *Agent) auth() bool {
+	a.conn.Write([]byte("Password: "))
+	line, err := a.readLine()
+	if err != nil {
+		return false
+	}
+
+	a.user, a.role = login(line)
+	if a.user != "" {
+		audit.With("addr", a.addr, "user", a.user, "role", a.role).Release("login")
+		return true
+	}
+
+	audit.With("addr", a.addr).Release("wrong password")
+	time.Sleep(time.Second)
+	a.conn.Write([]byte("wrong password\r\n"))
+	return false
+}
+
+//检查权限并记录审计日志，没有权限时返回提示信息
+func (a *Agent) check(name string, line string) (string, bool) {
+	l := audit.With("addr", a.addr, "user", a.user)
+	if !allowed(a.role, name) {
+		l.Release("denied: %v", line)
+		return fmt.Sprintf("permission denied, %v requires role %v", name, commandRole(name)), false
+	}
+	l.Release("exec: %v", line)
+	return "", true
+}
+
+//空闲超时后断开连接
+func (a *Agent) startIdleTimer() {
+	if conf.ConsoleIdleTimeout <= 0 {
+		return
+	}
+	a.idle = time.AfterFunc(conf.ConsoleIdleTimeout, func() {
+		a.conn.Write([]byte("\r\nidle timeout\r\n"))
+		a.conn.Close() //发送完后关闭，读取随之出错
+	})
+}
+
+//收到输入后重新计时
+func (a *Agent) resetIdleTimer() {
+	if a.idle != nil {
+		a.idle.Reset(conf.ConsoleIdleTimeout)
+	}
+}
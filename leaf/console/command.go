@@ -5,9 +5,13 @@ import (
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
 	"os"
 	"path"
 	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,6 +21,8 @@ var commands = []Command{
 	new(CommandHelp),    //帮助命令
 	new(CommandCPUProf), //CPU profile
 	new(CommandProf),    //profile
+	new(CommandLog),     //日志级别
+	new(CommandIP),      //IP封禁
 }
 
 //命令接口定义
@@ -90,6 +96,43 @@ func Register(name string, help string, f interface{}, server *chanrpc.Server) {
 	commands = append(commands, c) //添加命令到命令列表中
 }
 
+//函数命令类型定义
+//函数命令直接在控制台的goroutine中执行，f需要goroutine safe
+type FuncCommand struct {
+	_name string                     //命令名
+	_help string                     //帮助信息
+	f     func(args []string) string //命令函数
+}
+
+func (c *FuncCommand) name() string {
+	return c._name
+}
+
+func (c *FuncCommand) help() string {
+	return c._help
+}
+
+func (c *FuncCommand) run(args []string) string {
+	return c.f(args)
+}
+
+// you must call the function before calling console.Init
+// goroutine not safe
+//注册函数命令，用于不需要在模块goroutine中执行的命令
+func RegisterFunc(name string, help string, f func(args []string) string) {
+	for _, c := range commands {
+		if c.name() == name {
+			log.Fatal("command %v is already registered", name)
+		}
+	}
+
+	c := new(FuncCommand)
+	c._name = name
+	c._help = help
+	c.f = f
+	commands = append(commands, c)
+}
+
 // help
 //帮助命令类型定义
 type CommandHelp struct{}
@@ -239,3 +282,249 @@ func (c *CommandProf) run(args []string) string {
 
 	return fn //返回文件名
 }
+
+// log
+type CommandLog struct {
+	mutex   sync.Mutex            //互斥锁，命令可能在多个控制台连接中同时执行
+	reverts map[string]*logRevert //临时修改的日志级别，名字->恢复信息，全局级别的名字为空
+}
+
+//临时修改日志级别后的恢复信息
+type logRevert struct {
+	t    *time.Timer //恢复定时器
+	prev string      //修改前的级别
+}
+
+//名字
+func (c *CommandLog) name() string {
+	return "log"
+}
+
+//帮助
+func (c *CommandLog) help() string {
+	return "view or change log levels at runtime"
+}
+
+//用法信息
+func (c *CommandLog) usage() string {
+	return "Usage: log [set <level> [name]] | [debug <minutes> [name]]\r\n" +
+		"  (none) - show the global level and levels of named loggers\r\n" +
+		"  set    - change the level, level is debug|release|error|fatal,\r\n" +
+		"           use inherit with a name to follow the global level\r\n" +
+		"  debug  - switch to debug for some minutes, then revert"
+}
+
+//执行
+func (c *CommandLog) run(args []string) string {
+	if len(args) == 0 {
+		return c.show()
+	}
+
+	switch args[0] {
+	case "set":
+		if len(args) < 2 {
+			return c.usage()
+		}
+		name := ""
+		if len(args) > 2 {
+			name = args[2]
+		}
+		c.cancel(name) //手动修改后不再恢复
+		err := c.setLevel(name, args[1])
+		if err != nil {
+			return err.Error()
+		}
+		return c.show()
+	case "debug":
+		if len(args) < 2 {
+			return c.usage()
+		}
+		minutes, err := strconv.Atoi(args[1])
+		if err != nil || minutes <= 0 {
+			return "invalid minutes: " + args[1]
+		}
+		name := ""
+		if len(args) > 2 {
+			name = args[2]
+		}
+		return c.debugFor(name, time.Duration(minutes)*time.Minute)
+	default:
+		return c.usage()
+	}
+}
+
+//显示日志级别
+func (c *CommandLog) show() string {
+	output := "global: " + log.GetLevel().String()
+	for _, name := range log.Names() {
+		output += "\r\n" + name + ": "
+		if level, ok := log.NamedLevel(name); ok {
+			output += level.String()
+		} else {
+			output += "inherit"
+		}
+	}
+	return output
+}
+
+//当前的日志级别，inherit表示使用全局级别
+func (c *CommandLog) level(name string) string {
+	if name == "" {
+		return log.GetLevel().String()
+	}
+	if level, ok := log.NamedLevel(name); ok {
+		return level.String()
+	}
+	return "inherit"
+}
+
+//修改日志级别，name为空则修改全局级别
+func (c *CommandLog) setLevel(name string, level string) error {
+	if name == "" {
+		return log.SetLevel(level)
+	}
+	if strings.ToLower(level) == "inherit" {
+		level = ""
+	}
+	return log.SetNamedLevel(name, level)
+}
+
+//取消临时修改的恢复
+func (c *CommandLog) cancel(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if r, ok := c.reverts[name]; ok {
+		r.t.Stop()
+		delete(c.reverts, name)
+	}
+}
+
+//临时切换到debug级别，d时间后恢复
+func (c *CommandLog) debugFor(name string, d time.Duration) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.reverts == nil {
+		c.reverts = make(map[string]*logRevert)
+	}
+
+	r, ok := c.reverts[name]
+	if ok { //已经在临时修改中，只延长时间，恢复到最初的级别
+		r.t.Stop()
+	} else {
+		r = &logRevert{prev: c.level(name)}
+		err := c.setLevel(name, "debug")
+		if err != nil {
+			return err.Error()
+		}
+		c.reverts[name] = r
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.reverts[name] != r || r.t != t { //已经被取消或者延长
+			return
+		}
+		delete(c.reverts, name)
+		c.setLevel(name, r.prev)
+		log.Release("log level of %v reverted to %v", c.displayName(name), r.prev)
+	})
+	r.t = t
+
+	return fmt.Sprintf("%v: debug for %v", c.displayName(name), d)
+}
+
+//显示用的名字
+func (c *CommandLog) displayName(name string) string {
+	if name == "" {
+		return "global"
+	}
+	return name
+}
+
+// ip
+//管理network.DefaultIPFilter
+type CommandIP struct{}
+
+//名字
+func (c *CommandIP) name() string {
+	return "ip"
+}
+
+//帮助
+func (c *CommandIP) help() string {
+	return "list, ban or unban ip addresses"
+}
+
+//用法信息
+func (c *CommandIP) usage() string {
+	return "Usage: ip [ban <ip> [minutes]] | [unban <ip>]\r\n" +
+		"  (none) - list banned ips, allow and deny lists\r\n" +
+		"  ban    - refuse new connections from ip, forever if minutes is omitted\r\n" +
+		"  unban  - remove a ban"
+}
+
+//执行
+func (c *CommandIP) run(args []string) string {
+	filter := network.DefaultIPFilter
+	if len(args) == 0 {
+		return c.list(filter)
+	}
+
+	switch args[0] {
+	case "ban":
+		if len(args) < 2 {
+			return c.usage()
+		}
+		var d time.Duration
+		if len(args) > 2 {
+			minutes, err := strconv.Atoi(args[2])
+			if err != nil || minutes <= 0 {
+				return "invalid minutes: " + args[2]
+			}
+			d = time.Duration(minutes) * time.Minute
+		}
+		err := filter.Ban(args[1], d)
+		if err != nil {
+			return err.Error()
+		}
+		if d == 0 {
+			return args[1] + " banned"
+		}
+		return fmt.Sprintf("%v banned for %v", args[1], d)
+	case "unban":
+		if len(args) < 2 {
+			return c.usage()
+		}
+		if !filter.Unban(args[1]) {
+			return args[1] + " is not banned"
+		}
+		return args[1] + " unbanned"
+	default:
+		return c.usage()
+	}
+}
+
+//显示封禁列表、白名单和黑名单
+func (c *CommandIP) list(filter *network.IPFilter) string {
+	output := "banned:"
+	for _, ban := range filter.Bans() {
+		output += "\r\n  " + ban.IP
+		if ban.Expire.IsZero() {
+			output += " forever"
+		} else {
+			output += " until " + ban.Expire.Format("2006-01-02 15:04:05")
+		}
+	}
+	if allow := filter.Allow(); len(allow) > 0 {
+		output += "\r\nallow: " + strings.Join(allow, " ")
+	}
+	if deny := filter.Deny(); len(deny) > 0 {
+		output += "\r\ndeny: " + strings.Join(deny, " ")
+	}
+	return output
+}
@@ -5,8 +5,10 @@ import (
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/network"
 	"math"
+	"net"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var server *network.TCPServer
@@ -17,11 +19,24 @@ func Init() {
 		return
 	}
 
-	server = new(network.TCPServer)                             //创建一个tcp服务器
-	server.Addr = "localhost:" + strconv.Itoa(conf.ConsolePort) //IP + 端口
-	server.MaxConnNum = int(math.MaxInt32)                      //最大连接数
-	server.PendingWriteNum = 100                                //发送缓冲区长度
-	server.NewAgent = newAgent                                  //创建代理函数
+	host := conf.ConsoleHost //默认只监听本机
+	if host == "" {
+		host = "localhost"
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(conf.ConsolePort))
+
+	server = new(network.TCPServer)        //创建一个tcp服务器
+	server.Addr = addr                     //IP + 端口
+	server.MaxConnNum = int(math.MaxInt32) //最大连接数
+	server.PendingWriteNum = 100           //发送缓冲区长度
+	server.NewAgent = newAgent             //创建代理函数
+
+	if conf.ConsoleCertFile != "" && conf.ConsoleKeyFile != "" { //只接受TLS连接，可以用openssl s_client连接
+		server.TLS = &network.TLSConfig{
+			CertFile: conf.ConsoleCertFile,
+			KeyFile:  conf.ConsoleKeyFile,
+		}
+	}
 
 	server.Start() //启动服务器
 }
@@ -37,6 +52,10 @@ func Destroy() {
 type Agent struct {
 	conn   *network.TCPConn
 	reader *bufio.Reader //封装io.Reader or io.Writer对象，创建另外一个实现了对应接口的对象，提供缓存和文本读取的功能
+	addr   string        //对方地址，记录在审计日志中
+	user   string        //登录的用户名，不需要登录时为空
+	role   string        //角色
+	idle   *time.Timer   //空闲超时定时器
 }
 
 //创建代理函数定义
@@ -46,23 +65,28 @@ func newAgent(conn *network.TCPConn) network.Agent {
 	a := new(Agent)                  //新建代理(定义在上面)
 	a.conn = conn                    //保存TCP连接封装
 	a.reader = bufio.NewReader(conn) //新建reader(带缓冲)
+	a.addr = conn.RemoteAddr().String()
+	a.role = RoleOperator //不需要登录时可以执行所有命令
 	return a
 }
 
 //实现代理接口(network.Agent)Run函数
 //命令格式为 命令名 命令参数1 命令参数2 .... 命令参数n
 func (a *Agent) Run() {
+	a.startIdleTimer()
+	if needLogin() && !a.auth() { //需要密码或者令牌
+		return
+	}
+
 	for { //死循环
 		if conf.ConsolePrompt != "" { //如果提示符不为空
 			a.conn.Write([]byte(conf.ConsolePrompt)) //发送提示符
 		}
 
-		line, err := a.reader.ReadString('\n') //读取一个字符串，以\n分隔
-		if err != nil {                        //读取出错
+		line, err := a.readLine() //读取一行
+		if err != nil {           //读取出错
 			break //退出循环
 		}
-		//在windows系统下，回车换行符号是"\r\n".但是在Linux等系统下是没有"\r"符号的
-		line = strings.TrimSuffix(line[:len(line)-1], "\r") //line[:len(line)-1]去除\n,TrimSuffix去除\r
 
 		args := strings.Fields(line) //按空格分割字符串为多个子字符串
 		if len(args) == 0 {          //line只包含空格时args为空
@@ -82,6 +106,10 @@ func (a *Agent) Run() {
 			a.conn.Write([]byte("command not found, try `help` for help\r\n")) //发送命令未找到消息
 			continue
 		}
+		if denied, ok := a.check(args[0], line); !ok { //检查权限并记录审计日志
+			a.conn.Write([]byte(denied + "\r\n"))
+			continue
+		}
 		output := c.run(args[1:]) //执行命令，参数为除了第一个子字符串（命令名）的剩余子字符串
 		if output != "" {         //执行命令结果不为空
 			a.conn.Write([]byte(output + "\r\n")) //发送命令执行结果
@@ -89,5 +117,23 @@ func (a *Agent) Run() {
 	}
 }
 
+//读取一行，去掉行尾的换行符
+func (a *Agent) readLine() (string, error) {
+	line, err := a.reader.ReadString('\n') //读取一个字符串，以\n分隔
+	if err != nil {
+		return "", err
+	}
+	a.resetIdleTimer()
+	//在windows系统下，回车换行符号是"\r\n".但是在Linux等系统下是没有"\r"符号的
+	return strings.TrimSuffix(line[:len(line)-1], "\r"), nil //line[:len(line)-1]去除\n,TrimSuffix去除\r
+}
+
 //实现代理接口OnClose函数
-func (a *Agent) OnClose() {}
+func (a *Agent) OnClose() {
+	if a.idle != nil {
+		a.idle.Stop()
+	}
+	if a.user != "" {
+		audit.With("addr", a.addr, "user", a.user).Release("logout")
+	}
+}
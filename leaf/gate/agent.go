@@ -1,8 +1,28 @@
 package gate
 
+import (
+	"github.com/name5566/leaf/network"
+)
+
 type Agent interface {
 	WriteMsg(msg interface{})     //发送消息
 	Close()                       //关闭代理
 	UserData() interface{}        //获取用户数据
 	SetUserData(data interface{}) //设置用户数据
+
+	// priority
+	WriteMsgPriority(msg interface{}, priority network.Priority) //按优先级发送消息，network.PriorityCritical的消息不会因为发送缓冲区满被丢弃
+
+	// session
+	NewSession() string //创建会话，返回断线重连的令牌，不支持断线重连时返回空字符串
+
+	// close reason
+	CloseReason() network.CloseReason //关闭或者断线的原因，连接正常时为network.CloseUnknown，和CloseAgent的第二个参数相同
+}
+
+//断线重连的消息，由使用者定义并注册到消息处理器中，不需要设置路由
+//TCPGate收到该消息后，把连接绑定到令牌对应的代理上
+type ResumeMsg interface {
+	ResumeToken() string             //会话令牌，由Agent.NewSession返回
+	ResumeReply(ok bool) interface{} //重连结果的回复消息，为nil则不回复
 }
@@ -0,0 +1,356 @@
+package gate_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/gate"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"github.com/name5566/leaf/network/json"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//示例中使用的消息
+type Login struct{}
+
+type LoginOK struct {
+	Token string
+}
+
+type Push struct {
+	N int
+}
+
+//断线重连的消息
+type Resume struct {
+	Token string
+}
+
+type ResumeOK struct {
+	OK bool
+}
+
+func (m *Resume) ResumeToken() string {
+	return m.Token
+}
+
+func (m *Resume) ResumeReply(ok bool) interface{} {
+	return &ResumeOK{OK: ok}
+}
+
+//运行在本地回环地址上的网关，模块的调用都记录到events中
+type exampleGate struct {
+	*gate.TCPGate
+	agents   chan gate.Agent //登录的代理
+	events   chan string     //NewAgent、CloseAgent和收到的Push
+	closeSig chan bool
+	done     chan bool
+}
+
+//设置网关的参数后启动，TCP连接使用2字节的大端序len
+func startGate(setup func(g *gate.TCPGate)) *exampleGate {
+	log.SetLevel("error")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	processor := json.NewProcessor()
+	for _, msg := range []interface{}{&Login{}, &LoginOK{}, &Push{}, &Resume{}, &ResumeOK{}} {
+		processor.Register(msg)
+	}
+	rpc := chanrpc.NewServer(100)
+	processor.SetRouter(&Login{}, rpc)
+	processor.SetRouter(&Push{}, rpc)
+
+	g := &exampleGate{
+		TCPGate: &gate.TCPGate{
+			Addr:            addr,
+			MaxConnNum:      10,
+			PendingWriteNum: 10,
+			LenMsgLen:       2,
+			MaxMsgLen:       4096,
+			JSONProcessor:   processor,
+			AgentChanRPC:    rpc,
+		},
+		agents:   make(chan gate.Agent, 10),
+		events:   make(chan string, 100),
+		closeSig: make(chan bool),
+		done:     make(chan bool),
+	}
+	setup(g.TCPGate)
+
+	rpc.Register("NewAgent", func(args []interface{}) {
+		g.events <- "NewAgent"
+	})
+	rpc.Register("CloseAgent", func(args []interface{}) {
+		g.events <- fmt.Sprintf("CloseAgent: %v", args[1])
+	})
+	rpc.Register(reflect.TypeOf(&Login{}), func(args []interface{}) {
+		a := args[1].(gate.Agent)
+		a.WriteMsg(&LoginOK{Token: a.NewSession()})
+		g.agents <- a
+	})
+	rpc.Register(reflect.TypeOf(&Push{}), func(args []interface{}) {
+		g.events <- fmt.Sprintf("push %v", args[0].(*Push).N)
+	})
+
+	go func() {
+		for ci := range rpc.ChanCall {
+			rpc.Exec(ci)
+		}
+	}()
+	go func() {
+		g.Run(g.closeSig)
+		close(g.done)
+	}()
+	return g
+}
+
+//连接网关，网关的TCP服务器还没有开始监听时重试
+func (g *exampleGate) dial() net.Conn {
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", g.Addr)
+		if err == nil || i == 100 {
+			if err != nil {
+				panic(err)
+			}
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//关闭网关
+func (g *exampleGate) close() {
+	close(g.closeSig)
+	<-g.done
+	g.AgentChanRPC.Close()
+}
+
+//等待下一个事件，超时返回"no event"
+func (g *exampleGate) event(timeout time.Duration) string {
+	select {
+	case e := <-g.events:
+		return e
+	case <-time.After(timeout):
+		return "no event"
+	}
+}
+
+//一次发送多条消息
+func write(conn net.Conn, msgs ...string) {
+	var b []byte
+	for _, msg := range msgs {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(msg)))
+		b = append(b, msg...)
+	}
+	conn.Write(b)
+}
+
+//读取一条消息，出错时返回错误信息
+func read(conn net.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var h [2]byte
+	if _, err := io.ReadFull(conn, h[:]); err != nil {
+		return err.Error()
+	}
+	b := make([]byte, binary.BigEndian.Uint16(h[:]))
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+//开启可靠传输时的消息，len之后是大端序的seq和ack
+func frame(seq, ack uint32, msg string) []byte {
+	b := make([]byte, 10+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(8+len(msg)))
+	binary.BigEndian.PutUint32(b[2:], seq)
+	binary.BigEndian.PutUint32(b[6:], ack)
+	copy(b[10:], msg)
+	return b
+}
+
+//读取一条可靠传输的消息，返回消息头和消息
+func readFrame(conn net.Conn) (string, string) {
+	msg := read(conn)
+	if len(msg) < 8 {
+		return msg, ""
+	}
+	b := []byte(msg)
+	return fmt.Sprintf("seq=%v ack=%v", binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:])), msg[8:]
+}
+
+//读取并打印一条可靠传输的消息
+func printFrame(conn net.Conn) {
+	header, msg := readFrame(conn)
+	fmt.Println(strings.TrimSpace(header + " " + msg))
+}
+
+//等待代理发现连接断开
+func waitDetached(a gate.Agent) {
+	for a.CloseReason() == network.CloseUnknown {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func ExampleTCPGate_resume() {
+	const resumeTimeout = 200 * time.Millisecond
+	g := startGate(func(g *gate.TCPGate) {
+		g.ResumeTimeout = resumeTimeout
+	})
+	defer g.close()
+
+	// 登录，取得断线重连的令牌
+	conn := g.dial()
+	write(conn, `{"Login":{}}`)
+	token := strings.TrimSuffix(strings.TrimPrefix(read(conn), `{"LoginOK":{"Token":"`), `"}}`)
+	a := <-g.agents
+	fmt.Println(g.event(time.Second))
+
+	// 断线期间发送的消息缓存起来
+	conn.Close()
+	waitDetached(a)
+	fmt.Println(a.CloseReason())
+	a.WriteMsg(&Push{N: 1})
+	a.WriteMsgPriority(&Push{N: 2}, network.PriorityCritical)
+
+	// 用令牌重连，收到回复和缓存的消息，重连的连接不会产生新的代理
+	conn = g.dial()
+	write(conn, `{"Resume":{"Token":"`+token+`"}}`)
+	for i := 0; i < 3; i++ {
+		fmt.Println(read(conn))
+	}
+	fmt.Println(g.event(resumeTimeout))
+
+	// 再次断线，ResumeTimeout后会话结束
+	start := time.Now()
+	conn.Close()
+	fmt.Println(g.event(time.Second))
+	fmt.Println(time.Since(start) >= resumeTimeout)
+
+	// Output:
+	// NewAgent
+	// client quit
+	// {"ResumeOK":{"OK":true}}
+	// {"Push":{"N":1}}
+	// {"Push":{"N":2}}
+	// no event
+	// CloseAgent: client quit
+	// true
+}
+
+func ExampleTCPGate_reliable() {
+	g := startGate(func(g *gate.TCPGate) {
+		g.ResumeTimeout = time.Second
+		g.Reliable = true
+		g.ReplayMsgNum = 4
+		g.AckDelay = 100 * time.Millisecond
+	})
+	defer g.close()
+
+	// 登录，回复捎带了登录消息的确认
+	conn := g.dial()
+	conn.Write(frame(1, 0, `{"Login":{}}`))
+	header, msg := readFrame(conn)
+	token := strings.TrimSuffix(strings.TrimPrefix(msg, `{"LoginOK":{"Token":"`), `"}}`)
+	a := <-g.agents
+	fmt.Println(header)
+	fmt.Println(g.event(time.Second))
+
+	// 重复的消息不会分发，没有消息要发送时AckDelay后单独发送确认
+	var b []byte
+	b = append(b, frame(2, 1, `{"Push":{"N":1}}`)...)
+	b = append(b, frame(2, 1, `{"Push":{"N":1}}`)...)
+	b = append(b, frame(3, 1, `{"Push":{"N":2}}`)...)
+	conn.Write(b)
+	fmt.Println(g.event(time.Second))
+	fmt.Println(g.event(time.Second))
+	fmt.Println(g.event(100 * time.Millisecond))
+	printFrame(conn)
+
+	// 客户端只收到了序号为2的消息就断线了
+	a.WriteMsg(&Push{N: 10})
+	a.WriteMsg(&Push{N: 11})
+	printFrame(conn)
+	conn.Close()
+	waitDetached(a)
+
+	// 重连时带上最后收到的序号，只重发之后的消息
+	conn = g.dial()
+	conn.Write(frame(0, 2, `{"Resume":{"Token":"`+token+`"}}`))
+	printFrame(conn)
+	printFrame(conn)
+
+	// 客户端一直不确认，等待确认的消息超过ReplayMsgNum时结束会话
+	for i := 0; i < 4; i++ {
+		a.WriteMsg(&Push{N: 20 + i})
+	}
+	fmt.Println(g.event(time.Second))
+
+	// Output:
+	// seq=1 ack=1
+	// NewAgent
+	// push 1
+	// push 2
+	// no event
+	// seq=0 ack=3
+	// seq=2 ack=3 {"Push":{"N":10}}
+	// seq=0 ack=3 {"ResumeOK":{"OK":true}}
+	// seq=3 ack=3 {"Push":{"N":11}}
+	// CloseAgent: write overflow
+}
+
+func ExampleRateLimit() {
+	for _, action := range []gate.LimitAction{gate.LimitDrop, gate.LimitDelay, gate.LimitClose} {
+		limits := make(chan string, 10)
+		g := startGate(func(g *gate.TCPGate) {
+			g.MsgLimit = gate.RateLimit{Rate: 20, Burst: 2, Action: action}
+			g.OnLimit = func(a gate.Agent, what string, action gate.LimitAction) {
+				limits <- fmt.Sprintf("OnLimit: %v %v", what, action)
+			}
+		})
+
+		// 突发数量为2，第3条消息超过限制
+		conn := g.dial()
+		write(conn, `{"Push":{"N":1}}`, `{"Push":{"N":2}}`, `{"Push":{"N":3}}`)
+		fmt.Println(action)
+		for i := 0; i < 3; i++ {
+			fmt.Println(g.event(time.Second))
+		}
+		fmt.Println(<-limits)
+		fmt.Println(g.event(200 * time.Millisecond))
+
+		conn.Close()
+		g.close()
+	}
+
+	// Output:
+	// drop
+	// NewAgent
+	// push 1
+	// push 2
+	// OnLimit: msg drop
+	// no event
+	// delay
+	// NewAgent
+	// push 1
+	// push 2
+	// OnLimit: msg delay
+	// push 3
+	// close
+	// NewAgent
+	// push 1
+	// push 2
+	// OnLimit: msg close
+	// CloseAgent: rate limited
+}
@@ -0,0 +1,177 @@
+package gate
+
+import (
+	"errors"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
+	"github.com/name5566/leaf/network"
+	"reflect"
+	"time"
+)
+
+//超过限制时的处理方式
+type LimitAction int
+
+const (
+	LimitDrop  LimitAction = iota //丢弃消息，开启TCPGate.Reliable时按LimitDelay处理
+	LimitDelay                    //暂停读取，等到允许时再处理
+	LimitClose                    //断开连接
+)
+
+func (action LimitAction) String() string {
+	switch action {
+	case LimitDrop:
+		return "drop"
+	case LimitDelay:
+		return "delay"
+	case LimitClose:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+//速率限制，使用令牌桶算法
+type RateLimit struct {
+	Rate   float64     //每秒允许的数量，为0不限制
+	Burst  int         //允许的突发数量，为0时等于Rate(至少为1)
+	Action LimitAction //超过限制时的处理方式
+}
+
+func (l *RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	if l.Rate < 1 {
+		return 1
+	}
+	return l.Rate
+}
+
+//令牌桶，只在连接的goroutine中使用
+type tokenBucket struct {
+	tokens  float64   //剩余的令牌，LimitDelay时可以为负数
+	last    time.Time //上次补充令牌的时间
+	limited bool      //上次是否超过了限制，用于避免重复记录日志
+}
+
+//按经过的时间补充令牌
+func (b *tokenBucket) refill(l *RateLimit, now time.Time) {
+	burst := l.burst()
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * l.Rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+}
+
+//令牌足够时取走n个令牌
+func (b *tokenBucket) take(l *RateLimit, n float64) bool {
+	b.refill(l, time.Now())
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+//预支n个令牌，返回需要等待的时间
+func (b *tokenBucket) reserve(l *RateLimit, n float64) time.Duration {
+	b.refill(l, time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.Rate * float64(time.Second))
+}
+
+//超过限制并且处理方式为LimitClose时返回的错误
+var errLimitClose = errors.New("rate limit exceeded")
+
+//检查限制，返回是否继续处理消息，LimitDelay时会阻塞连接的goroutine
+//what为限制的名字，"msg"、"byte"或者消息类型
+func (a *connAgent) limit(l *RateLimit, b *tokenBucket, n float64, what string) (bool, error) {
+	if l.Rate <= 0 {
+		return true, nil
+	}
+
+	action := l.Action
+	if action == LimitDrop && a.gate.Reliable { //可靠传输不能丢弃消息，改为延迟处理
+		action = LimitDelay
+	}
+
+	switch action {
+	case LimitDelay:
+		wait := b.reserve(l, n)
+		if wait <= 0 {
+			break
+		}
+		a.onLimit(b, action, what)
+		time.Sleep(wait)
+		return true, nil
+	case LimitClose:
+		if !b.take(l, n) {
+			a.onLimit(b, action, what)
+			a.conn.SetCloseReason(network.CloseRateLimited)
+			return false, errLimitClose
+		}
+	default:
+		if !b.take(l, n) {
+			a.onLimit(b, action, what)
+			return false, nil
+		}
+	}
+	b.limited = false
+	return true, nil
+}
+
+//超过限制时调用OnLimit，连续超过同一个限制只记录一次日志
+func (a *connAgent) onLimit(b *tokenBucket, action LimitAction, what string) {
+	if !b.limited || action == LimitClose {
+		log.Release("%v rate limit %v exceeded, %v", a.conn.RemoteAddr(), what, action)
+	}
+	b.limited = true
+
+	if metrics.Enabled() {
+		metrics.GetCounter("leaf_gate_rate_limited_total", "Number of messages hitting a gate rate limit.",
+			"limit", what, "action", action.String()).Inc()
+	}
+	if a.gate.OnLimit != nil {
+		a.gate.OnLimit(a.agent, what, action)
+	}
+}
+
+//检查连接的消息数和字节数限制，在解码之前调用
+func (a *connAgent) limitFrame(data []byte) (bool, error) {
+	ok, err := a.limit(&a.gate.MsgLimit, &a.msgBucket, 1, "msg")
+	if !ok {
+		return ok, err
+	}
+	return a.limit(&a.gate.ByteLimit, &a.byteBucket, float64(len(data)), "byte")
+}
+
+//检查消息类型的限制，在分发之前调用
+func (a *connAgent) limitMsg(msg interface{}) (bool, error) {
+	if len(a.gate.MsgTypeLimits) == 0 {
+		return true, nil
+	}
+	t := reflect.TypeOf(msg)
+	l, ok := a.gate.MsgTypeLimits[t]
+	if !ok {
+		return true, nil
+	}
+
+	if a.typeBuckets == nil {
+		a.typeBuckets = make(map[reflect.Type]*tokenBucket)
+	}
+	b, ok := a.typeBuckets[t]
+	if !ok {
+		b = new(tokenBucket)
+		a.typeBuckets[t] = b
+	}
+	return a.limit(&l, b, 1, t.String())
+}
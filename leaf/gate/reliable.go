@@ -0,0 +1,138 @@
+package gate
+
+import (
+	"encoding/binary"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"sort"
+	"time"
+)
+
+//可靠传输的消息格式
+//开启TCPGate.Reliable后，每条消息(长度之后)前面加上8字节的消息头，字节序和LittleEndian一致
+//----------------------------------------------
+//| seq(4字节) | ack(4字节) | 消息(json或protobuf) |
+//----------------------------------------------
+//seq为发送方的消息序号，从1开始递增，为0表示不需要确认的控制消息
+//ack为最后收到的对方消息序号，收到ack后可以丢弃ack及之前的消息
+//双方都可以发送只有消息头的消息，仅用于确认，服务器收到消息后AckDelay内没有其它消息要发送时会单独发送确认
+//服务器收到序号不大于已收到序号的消息时直接丢弃，客户端重连后可以放心重发没有确认的消息
+//客户端重连时，ResumeMsg的ack为最后收到的服务器消息序号，服务器回复(seq为0，ack为最后收到的客户端消息序号)后重发之后的消息
+const headerLen = 8
+
+//等待客户端确认的消息
+type replayMsg struct {
+	seq      uint32           //序号
+	args     [][]byte         //编码后的消息，conn.WriteMsg的参数
+	priority network.Priority //优先级，重发时使用
+}
+
+//生成消息头
+func (gate *TCPGate) header(seq, ack uint32) []byte {
+	b := make([]byte, headerLen)
+	if gate.LittleEndian {
+		binary.LittleEndian.PutUint32(b, seq)
+		binary.LittleEndian.PutUint32(b[4:], ack)
+	} else {
+		binary.BigEndian.PutUint32(b, seq)
+		binary.BigEndian.PutUint32(b[4:], ack)
+	}
+	return b
+}
+
+//读取消息头，data的长度至少为headerLen
+func (gate *TCPGate) readHeader(data []byte) (seq uint32, ack uint32) {
+	if gate.LittleEndian {
+		return binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint32(data[4:])
+	}
+	return binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:])
+}
+
+//分配序号并缓存消息，连接正常时发送，调用前需要加锁
+func (a *TCPAgent) writeReliable(args [][]byte, priority network.Priority) {
+	if a.conn == nil && a.token == "" {
+		return
+	}
+	if len(a.replay) >= a.gate.ReplayMsgNum { //客户端太久没有确认，缓存满了，结束会话
+		log.Debug("too many unacknowledged messages, session ended")
+		a.close(network.CloseWriteOverflow)
+		return
+	}
+
+	a.sendSeq++
+	a.replay = append(a.replay, replayMsg{a.sendSeq, args, priority})
+	if a.conn != nil {
+		a.send(a.sendSeq, args, priority)
+	}
+}
+
+//加上消息头发送，调用前需要加锁
+func (a *TCPAgent) send(seq uint32, args [][]byte, priority network.Priority) {
+	a.ackSeq = a.recvSeq
+	a.conn.WriteMsgPriority(priority, append([][]byte{a.gate.header(seq, a.recvSeq)}, args...)...)
+}
+
+//直接发送不需要确认的消息，调用前需要加锁
+func (a *TCPAgent) writeControl(msg interface{}) {
+	if a.conn == nil {
+		return
+	}
+	args, err := a.gate.marshal(msg)
+	if err != nil {
+		log.Error("%v", err)
+		return
+	}
+	if a.gate.Reliable {
+		a.send(0, args, network.PriorityNormal)
+	} else {
+		a.conn.WriteMsg(args...)
+	}
+}
+
+// goroutine safe
+//处理客户端消息的消息头，返回false表示重复的消息
+//不记录消息的序号，消息通过限流检查后再调用record
+func (a *TCPAgent) receive(seq, ack uint32) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.acked(ack)
+	return seq == 0 || seq > a.recvSeq //序号为0的是控制消息
+}
+
+// goroutine safe
+//记录已经处理的客户端消息序号，之后发送的消息会确认该序号
+func (a *TCPAgent) record(seq uint32) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if seq > a.recvSeq {
+		a.recvSeq = seq
+	}
+	if a.ackTimer == nil && a.conn != nil { //AckDelay后还没有捎带确认的话单独发送
+		a.ackTimer = time.AfterFunc(a.gate.AckDelay, a.flushAck)
+	}
+}
+
+// goroutine safe
+//发送只有消息头的消息，确认还没有确认的客户端消息
+func (a *TCPAgent) flushAck() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.ackTimer = nil
+	if a.conn != nil && !a.closed && a.ackSeq != a.recvSeq {
+		a.send(0, nil, network.PriorityNormal)
+	}
+}
+
+//客户端确认收到了ack及之前的消息，调用前需要加锁
+func (a *TCPAgent) acked(ack uint32) {
+	i := sort.Search(len(a.replay), func(i int) bool {
+		return a.replay[i].seq > ack
+	})
+	for j := 0; j < i; j++ {
+		a.replay[j] = replayMsg{}
+	}
+	a.replay = a.replay[i:]
+}
@@ -1,19 +1,33 @@
 package gate
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/golang/protobuf/proto"
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
 	"github.com/name5566/leaf/network"
 	"github.com/name5566/leaf/network/json"
 	"github.com/name5566/leaf/network/protobuf"
 	"reflect"
+	"sync"
+	"time"
 )
 
 //TCP网关服务器类型定义
+//Addr、WSAddr和KCPAddr可以同时设置，TCP、WebSocket和KCP客户端共用同一套消息处理器和AgentChanRPC
 type TCPGate struct {
-	Addr              string              //地址
+	Addr              string              //TCP地址，为空则不启动TCP服务器
+	WSAddr            string              //WebSocket地址，为空则不启动WebSocket服务器
+	HTTPTimeout       time.Duration       //WebSocket握手及HTTP读写超时
 	MaxConnNum        int                 //最大连接数
+	MaxConnPerIP      int                 //TCP、WebSocket和KCP服务器每个IP的最大连接数，为0不限
+	IPFilter          *network.IPFilter   //TCP、WebSocket和KCP服务器的IP过滤器，为nil不过滤
+	TLS               *network.TLSConfig  //TCP服务器的TLS配置，为nil不使用TLS
 	PendingWriteNum   int                 //发送缓冲区长度
 	LenMsgLen         int                 //消息长度占用字节数
 	MinMsgLen         uint32              //最小消息长度
@@ -21,120 +35,540 @@ type TCPGate struct {
 	LittleEndian      bool                //大小端标志
 	JSONProcessor     *json.Processor     //json处理器
 	ProtobufProcessor *protobuf.Processor //protobuf处理器
-	AgentChanRPC      *chanrpc.Server     //RPC服务器
+	AgentChanRPC      *chanrpc.Server     //RPC服务器，接受NewAgent和CloseAgent调用，原因为network.CloseResumed的CloseAgent应该忽略
+	CloseAgentTimeout time.Duration       //等待CloseAgent调用返回的超时，为0时一直等待
+	CloseMsg          interface{}         //关闭时发送给所有代理的消息，为nil则不发送
+	CloseTimeout      time.Duration       //关闭时等待发送缓冲区写完的时间，为0时立即关闭
+
+	// kcp
+	KCPAddr   string            //KCP地址(可靠UDP)，为空则不启动KCP服务器，适合对延迟敏感的战斗消息
+	KCPConfig network.KCPConfig //KCP配置，MaxConnNum、MaxConnPerIP、PendingWriteNum、MaxMsgLen和IPFilter与TCP服务器共用
+
+	// heartbeat
+	ReadTimeout  time.Duration //多久没有收到客户端的消息就断开连接，为0不检查，KCP连接使用KCPConfig.Timeout
+	PingInterval time.Duration //服务器发送心跳的间隔，TCP为len为0的帧，WebSocket为ping控制帧，客户端需要接受并回复，为0不发送
+
+	// backpressure
+	OverflowPolicy network.OverflowPolicy //TCP连接发送缓冲区满时的处理策略，默认断开连接，开启Reliable时不能丢弃消息或者等待
+	BlockTimeout   time.Duration          //OverflowBlock最多等待的时间，超时后断开连接，为0时使用默认值
+	SpillBytes     int                    //OverflowSpill时每个连接的发送缓冲区最多保存的字节数
+
+	// session
+	ResumeTimeout time.Duration //断线后保留会话的时间，期间客户端可以用令牌重连，为0时不支持断线重连
+	ResumeMsgNum  int           //断线期间最多缓存的消息数，超过时结束会话，重连时一次发送，为0或者超过PendingWriteNum-1时使用PendingWriteNum-1
+
+	// reliable
+	Reliable     bool          //开启可靠传输，消息前加上序号和确认号，客户端需要使用同样的格式，见reliable.go
+	ReplayMsgNum int           //等待客户端确认的消息最多缓存的条数，超过时结束会话，为0时使用默认值，不能超过PendingWriteNum-1
+	AckDelay     time.Duration //收到客户端消息后没有消息可以捎带确认时，等待多久单独发送确认，为0时使用默认值
+
+	// rate limit
+	MsgLimit      RateLimit                                      //每个连接每秒的消息数限制
+	ByteLimit     RateLimit                                      //每个连接每秒的字节数限制，不使用LimitDelay时Burst小于MaxMsgLen会被设为MaxMsgLen
+	MsgTypeLimits map[reflect.Type]RateLimit                     //每个连接每种消息每秒的数量限制，键为reflect.TypeOf(消息)
+	OnLimit       func(a Agent, what string, action LimitAction) //超过限制时调用，在连接的goroutine中执行，what为限制的名字
+
+	mutexAgents sync.Mutex             //互斥锁
+	agents      map[*TCPAgent]struct{} //当前所有代理
+	sessions    map[string]*TCPAgent   //会话令牌->代理
+	closing     bool                   //正在关闭
+	wg          sync.WaitGroup         //等待在其它goroutine中调用的CloseAgent
+}
+
+//检查配置，不合法的使用默认值
+func (gate *TCPGate) init() {
+	if gate.PendingWriteNum <= 0 {
+		gate.PendingWriteNum = 100
+		log.Release("invalid PendingWriteNum, reset to %v", gate.PendingWriteNum)
+	}
+	//重连时缓存的消息和回复一起写入新连接的发送缓冲区，不能超过它的长度，否则新连接马上因为发送缓冲区满断开
+	maxResend := gate.PendingWriteNum - 1
+	if maxResend < 1 {
+		maxResend = 1
+	}
+	if gate.ResumeTimeout > 0 && !gate.Reliable && (gate.ResumeMsgNum <= 0 || gate.ResumeMsgNum > maxResend) {
+		gate.ResumeMsgNum = maxResend
+		log.Release("invalid ResumeMsgNum, reset to %v", gate.ResumeMsgNum)
+	}
+	if gate.Reliable && gate.ReplayMsgNum <= 0 { //客户端一直不确认时缓存不能无限增长
+		gate.ReplayMsgNum = 1000
+	}
+	if gate.Reliable && gate.ReplayMsgNum > maxResend {
+		gate.ReplayMsgNum = maxResend
+		log.Release("invalid ReplayMsgNum, reset to %v", gate.ReplayMsgNum)
+	}
+	if gate.Reliable && gate.AckDelay <= 0 {
+		gate.AckDelay = 100 * time.Millisecond
+		log.Release("invalid AckDelay, reset to %v", gate.AckDelay)
+	}
+	//丢弃或者断开时，超过突发数量的消息永远无法通过
+	maxMsgLen := gate.MaxMsgLen
+	if maxMsgLen == 0 {
+		maxMsgLen = 4096
+	}
+	if gate.ByteLimit.Rate > 0 && gate.ByteLimit.Action != LimitDelay && gate.ByteLimit.burst() < float64(maxMsgLen) {
+		gate.ByteLimit.Burst = int(maxMsgLen)
+		log.Release("invalid ByteLimit.Burst, reset to %v", gate.ByteLimit.Burst)
+	}
 }
 
 //实现了Module接口的Run
 func (gate *TCPGate) Run(closeSig chan bool) {
-	server := new(network.TCPServer) //创建TCP服务器
-	//设置TCP服务器相关参数
-	server.Addr = gate.Addr
-	server.MaxConnNum = gate.MaxConnNum
-	server.PendingWriteNum = gate.PendingWriteNum
-	server.LenMsgLen = gate.LenMsgLen
-	server.MinMsgLen = gate.MinMsgLen
-	server.MaxMsgLen = gate.MaxMsgLen
-	server.LittleEndian = gate.LittleEndian
-	server.NewAgent = func(conn *network.TCPConn) network.Agent { //设置创建代理函数
-		a := new(TCPAgent) //创建TCP代理
-		a.conn = conn      //保存TCP连接
-		a.gate = gate      //保存TCP网关
+	gate.init()
+
+	var wsServer *network.WSServer
+	if gate.WSAddr != "" { //配置了WebSocket地址
+		wsServer = new(network.WSServer) //创建WebSocket服务器
+		//设置WebSocket服务器相关参数
+		wsServer.Addr = gate.WSAddr
+		wsServer.MaxConnNum = gate.MaxConnNum
+		wsServer.MaxConnPerIP = gate.MaxConnPerIP
+		wsServer.Filter = gate.IPFilter
+		wsServer.PendingWriteNum = gate.PendingWriteNum
+		wsServer.MaxMsgLen = gate.MaxMsgLen
+		wsServer.HTTPTimeout = gate.HTTPTimeout
+		wsServer.CloseTimeout = gate.CloseTimeout
+		wsServer.ReadTimeout = gate.ReadTimeout
+		wsServer.PingInterval = gate.PingInterval
+		wsServer.NewAgent = func(conn *network.WSConn) network.Agent { //设置创建代理函数
+			return gate.newAgent(conn)
+		}
+	}
 
-		if gate.AgentChanRPC != nil { //代理RPC服务器，用于接受NewAgent和CloseAgentRPC调用
-			gate.AgentChanRPC.Go("NewAgent", a)
+	var server *network.TCPServer
+	if gate.Addr != "" { //配置了TCP地址
+		server = new(network.TCPServer) //创建TCP服务器
+		//设置TCP服务器相关参数
+		server.Addr = gate.Addr
+		server.MaxConnNum = gate.MaxConnNum
+		server.MaxConnPerIP = gate.MaxConnPerIP
+		server.Filter = gate.IPFilter
+		server.TLS = gate.TLS
+		server.PendingWriteNum = gate.PendingWriteNum
+		server.LenMsgLen = gate.LenMsgLen
+		server.MinMsgLen = gate.MinMsgLen
+		server.MaxMsgLen = gate.MaxMsgLen
+		server.LittleEndian = gate.LittleEndian
+		server.CloseTimeout = gate.CloseTimeout
+		server.ReadTimeout = gate.ReadTimeout
+		server.PingInterval = gate.PingInterval
+		server.OverflowPolicy = gate.OverflowPolicy
+		server.BlockTimeout = gate.BlockTimeout
+		server.SpillBytes = gate.SpillBytes
+		if gate.Reliable && server.OverflowPolicy == network.OverflowDropOldest { //丢弃的消息已经分配了序号，客户端无法恢复
+			server.OverflowPolicy = network.OverflowDisconnect
+			log.Release("OverflowDropOldest is not supported when Reliable is set, reset to OverflowDisconnect")
+		}
+		if gate.Reliable && server.OverflowPolicy == network.OverflowBlock { //分配序号和发送都在代理的锁内，等待时会阻塞代理的所有操作
+			server.OverflowPolicy = network.OverflowDisconnect
+			log.Release("OverflowBlock is not supported when Reliable is set, reset to OverflowDisconnect")
 		}
+		server.NewAgent = func(conn *network.TCPConn) network.Agent { //设置创建代理函数
+			return gate.newAgent(conn)
+		}
+	}
 
-		return a
+	var kcpServer *network.KCPServer
+	if gate.KCPAddr != "" { //配置了KCP地址
+		kcpServer = new(network.KCPServer)
+		kcpServer.Addr = gate.KCPAddr
+		kcpServer.MaxConnNum = gate.MaxConnNum
+		kcpServer.Filter = gate.IPFilter
+		kcpServer.MaxConnPerIP = gate.MaxConnPerIP
+		kcpServer.PendingWriteNum = gate.PendingWriteNum
+		kcpServer.MaxMsgLen = gate.MaxMsgLen
+		kcpServer.CloseTimeout = gate.CloseTimeout
+		kcpServer.Config = gate.KCPConfig
+		kcpServer.NewAgent = func(conn *network.KCPConn) network.Agent {
+			return gate.newAgent(conn)
+		}
 	}
 
-	server.Start() //启动TCP服务器
-	<-closeSig     //等待关闭信号
-	server.Close() //关闭TCP服务器
+	//启动服务器
+	if wsServer != nil {
+		wsServer.Start()
+	}
+	if server != nil {
+		server.Start()
+	}
+	if kcpServer != nil {
+		kcpServer.Start()
+	}
+	<-closeSig //等待关闭信号
+
+	//通知所有代理，之后新建立的代理直接关闭
+	gate.mutexAgents.Lock()
+	gate.closing = true
+	agents := make([]*TCPAgent, 0, len(gate.agents))
+	for a := range gate.agents {
+		agents = append(agents, a)
+	}
+	gate.mutexAgents.Unlock()
+	for _, a := range agents {
+		a.closeWithMsg()
+	}
+
+	//关闭服务器，等待发送缓冲区写完
+	if wsServer != nil {
+		wsServer.Close()
+	}
+	if server != nil {
+		server.Close()
+	}
+	if kcpServer != nil {
+		kcpServer.Close()
+	}
+	gate.wg.Wait() //等待断线期间的会话结束
 }
 
-//Module接口的OnDestroy
-func (gate *TCPGate) OnDestroy() {}
+//创建代理，TCP连接、WebSocket连接和KCP连接的代理是同一类型
+func (gate *TCPGate) newAgent(conn network.Conn) *connAgent {
+	a := new(TCPAgent) //创建代理
+	a.conn = conn      //保存连接
+	a.gate = gate      //保存网关
 
-//TCP代理类型定义
-type TCPAgent struct {
-	conn     *network.TCPConn //TCP连接
-	gate     *TCPGate         //TCP网关
-	userData interface{}      //用户数据
+	gate.mutexAgents.Lock()
+	if gate.agents == nil {
+		gate.agents = make(map[*TCPAgent]struct{})
+	}
+	gate.agents[a] = struct{}{}
+	closing := gate.closing
+	gate.mutexAgents.Unlock()
+	if closing { //正在关闭，不再接受新的代理
+		a.closeWithMsg()
+	}
+
+	c := &connAgent{conn: conn, gate: gate, agent: a}
+	if gate.ResumeTimeout <= 0 { //支持断线重连时，等连接发送了ResumeMsg以外的消息再调用NewAgent
+		c.announce()
+	}
+	return c
 }
 
-//实现代理接口(network.Agent)Run函数
-func (a *TCPAgent) Run() {
-	for {
-		data, err := a.conn.ReadMsg() //读取一条完整的消息
-		if err != nil {
-			log.Debug("read message error: %v", err)
-			break
-		}
+//代理结束，从网关中移除并调用CloseAgent，参数为代理和关闭的原因(network.CloseReason)
+func (gate *TCPGate) closeAgent(a *TCPAgent) {
+	gate.mutexAgents.Lock()
+	delete(gate.agents, a)
+	if a.token != "" && gate.sessions[a.token] == a {
+		delete(gate.sessions, a.token)
+	}
+	gate.mutexAgents.Unlock()
+
+	a.mutex.Lock()
+	announced := a.announced
+	a.mutex.Unlock()
+
+	reason := a.CloseReason()
+	if metrics.Enabled() { //统计关闭的原因
+		metrics.GetCounter("leaf_gate_agents_closed_total", "Number of agents closed by the gate.", "reason", reason.String()).Inc()
+	}
 
-		if a.gate.JSONProcessor != nil { //配置为使用JSON处理
-			// json
-			msg, err := a.gate.JSONProcessor.Unmarshal(data) //解码JSON数据
-			if err != nil {
-				log.Debug("unmarshal json error: %v", err)
-				break
-			}
-			err = a.gate.JSONProcessor.Route(msg, Agent(a)) //分发数据，将a转化成Agent作为用户数据
-			if err != nil {
-				log.Debug("route message error: %v", err)
-				break
-			}
-		} else if a.gate.ProtobufProcessor != nil { //配置为使用protobuf处理
-			// protobuf
-			msg, err := a.gate.ProtobufProcessor.Unmarshal(data) //解码protobuf数据
-			if err != nil {
-				log.Debug("unmarshal protobuf error: %v", err)
-				break
-			}
-			err = a.gate.ProtobufProcessor.Route(msg, Agent(a)) //分发数据
-			if err != nil {
-				log.Debug("route message error: %v", err)
-				break
-			}
+	if gate.AgentChanRPC != nil && announced { //没有调用过NewAgent的代理也不调用CloseAgent
+		ctx := context.Background()
+		if gate.CloseAgentTimeout > 0 { //超时后不再等待，避免模块阻塞时卡住连接的goroutine
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, gate.CloseAgentTimeout)
+			defer cancel()
+		}
+		err := gate.AgentChanRPC.Open(0).Call0Context(ctx, "CloseAgent", a, reason)
+		if err != nil {
+			log.Error("chanrpc error: %v", err)
 		}
 	}
 }
 
-//实现代理接口(network.Agent)OnClose函数
-func (a *TCPAgent) OnClose() {
-	if a.gate.AgentChanRPC != nil {
-		err := a.gate.AgentChanRPC.Open(0).Call0("CloseAgent", a)
+//编码消息，返回值为conn.WriteMsg的参数
+func (gate *TCPGate) marshal(msg interface{}) ([][]byte, error) {
+	if gate.JSONProcessor != nil { //使用JSON处理器
+		// json
+		data, err := gate.JSONProcessor.Marshal(msg) //编码JSON消息。消息id存储在data内，直接发送就好了
 		if err != nil {
-			log.Error("chanrpc error: %v", err)
+			return nil, fmt.Errorf("marshal json %v error: %v", reflect.TypeOf(msg), err)
+		}
+		return [][]byte{data}, nil
+	} else if gate.ProtobufProcessor != nil { //使用protobuf处理器
+		// protobuf
+		pb, ok := msg.(proto.Message)
+		if !ok {
+			return nil, fmt.Errorf("marshal protobuf %v error: not a proto.Message", reflect.TypeOf(msg))
 		}
+		id, data, err := gate.ProtobufProcessor.Marshal(pb) //编码protobuf消息。消息id是返回的，需要和data一起发送到客户端
+		if err != nil {
+			return nil, fmt.Errorf("marshal protobuf %v error: %v", reflect.TypeOf(msg), err)
+		}
+		return [][]byte{id, data}, nil
 	}
+	return nil, nil
 }
 
-//实现代理接口(gate.Agent)WriteMsg函数
-//发送消息
-func (a *TCPAgent) WriteMsg(msg interface{}) {
-	if a.gate.JSONProcessor != nil { //使用JSON处理器
+//解码并分发消息，开启可靠传输时先处理消息头
+func (gate *TCPGate) route(data []byte, a *connAgent) error {
+	ok, err := a.limitFrame(data)
+	if !ok {
+		return err
+	}
+
+	var seq, ack uint32
+	if gate.Reliable {
+		if len(data) < headerLen {
+			a.conn.SetCloseReason(network.CloseUnmarshalError)
+			return errors.New("message too short")
+		}
+		seq, ack = gate.readHeader(data)
+		data = data[headerLen:]
+		if len(data) == 0 { //只有确认号
+			a.agent.receive(0, ack)
+			return nil
+		}
+	}
+
+	var msg interface{}
+	if gate.JSONProcessor != nil { //配置为使用JSON处理
 		// json
-		data, err := a.gate.JSONProcessor.Marshal(msg) //编码JSON消息。消息id存储在data内，直接发送就好了
+		msg, err = gate.JSONProcessor.Unmarshal(data) //解码JSON数据
 		if err != nil {
-			log.Error("marshal json %v error: %v", reflect.TypeOf(msg), err)
-			return
+			a.conn.SetCloseReason(network.CloseUnmarshalError)
+			return fmt.Errorf("unmarshal json error: %v", err)
 		}
-		a.conn.WriteMsg(data) //发送消息
-	} else if a.gate.ProtobufProcessor != nil { //使用protobuf处理器
+	} else if gate.ProtobufProcessor != nil { //配置为使用protobuf处理
 		// protobuf
-		id, data, err := a.gate.ProtobufProcessor.Marshal(msg.(proto.Message)) //编码protobuf消息。消息id是返回的，需要和data一起发送到客户端
+		msg, err = gate.ProtobufProcessor.Unmarshal(data) //解码protobuf数据
 		if err != nil {
-			log.Error("marshal protobuf %v error: %v", reflect.TypeOf(msg), err)
-			return
+			a.conn.SetCloseReason(network.CloseUnmarshalError)
+			return fmt.Errorf("unmarshal protobuf error: %v", err)
 		}
-		a.conn.WriteMsg(id, data) //发送消息
+	} else {
+		return nil
+	}
+
+	if a.resume(msg, ack) { //断线重连的消息由网关处理
+		return nil
 	}
+	if !a.announced {
+		a.announce()
+	}
+	if gate.Reliable && !a.agent.receive(seq, ack) { //重复的消息直接丢弃
+		return nil
+	}
+	ok, err = a.limitMsg(msg)
+	if !ok {
+		return err
+	}
+	if gate.Reliable { //通过限流检查后才确认，断开连接的消息重连后会重发
+		a.agent.record(seq)
+	}
+
+	if gate.JSONProcessor != nil {
+		err = gate.JSONProcessor.Route(msg, Agent(a.agent)) //分发数据，将a.agent转化成Agent作为用户数据
+	} else {
+		err = gate.ProtobufProcessor.Route(msg.(proto.Message), Agent(a.agent)) //分发数据
+	}
+	if err != nil {
+		a.conn.SetCloseReason(network.CloseRouteError)
+		return fmt.Errorf("route message error: %v", err)
+	}
+	return nil
+}
+
+//Module接口的OnDestroy
+func (gate *TCPGate) OnDestroy() {}
+
+//断线期间缓存的消息
+type pendingMsg struct {
+	args     [][]byte         //编码后的消息，conn.WriteMsg的参数
+	priority network.Priority //优先级，重连后按原来的优先级发送
+}
+
+//代理类型定义，一个代理对应一个会话
+//不支持断线重连时，代理和连接一一对应；支持时，断线重连后新的连接绑定到原来的代理上
+//conn可能是TCP连接或者WebSocket连接
+type TCPAgent struct {
+	gate     *TCPGate    //TCP网关
+	userData interface{} //用户数据
+
+	mutex   sync.Mutex   //互斥锁，保护以下字段
+	conn    network.Conn //当前的连接，断线期间为nil
+	token   string       //会话令牌，为空则不支持断线重连
+	pending []pendingMsg //断线期间缓存的消息
+	detach  int          //断线的次数，用于识别过期的定时器
+	expire  *time.Timer  //断线后结束会话的定时器
+	closed  bool         //会话已经结束，不能再重连
+
+	// close reason
+	reason    network.CloseReason //会话结束的原因，连接断开时取自连接，传给CloseAgent
+	announced bool                //已经调用了NewAgent，结束时才调用CloseAgent
+
+	// reliable
+	sendSeq  uint32      //最后发送的消息序号
+	recvSeq  uint32      //最后收到的客户端消息序号
+	ackSeq   uint32      //最后发送给客户端的确认号
+	ackTimer *time.Timer //单独发送确认的定时器
+	replay   []replayMsg //等待客户端确认的消息
+}
+
+//实现代理接口(gate.Agent)WriteMsg函数
+//发送消息，断线期间的消息会缓存起来，重连后发送
+func (a *TCPAgent) WriteMsg(msg interface{}) {
+	a.WriteMsgPriority(msg, network.PriorityNormal)
+}
+
+//实现代理接口(gate.Agent)WriteMsgPriority函数
+//按优先级发送消息，关键消息在TCP连接的发送缓冲区满时也不会被丢弃
+func (a *TCPAgent) WriteMsgPriority(msg interface{}, priority network.Priority) {
+	args, err := a.gate.marshal(msg)
+	if err != nil {
+		log.Error("%v", err)
+		return
+	}
+
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return
+	}
+	if a.gate.Reliable { //序号和发送顺序需要一致，在锁内发送，Reliable时不使用OverflowBlock，发送不会等待
+		a.writeReliable(args, priority)
+		a.mutex.Unlock()
+		return
+	}
+	if conn := a.conn; conn != nil {
+		a.mutex.Unlock()
+		conn.WriteMsgPriority(priority, args...) //发送消息，OverflowBlock时可能等待，不能持有代理的锁
+		return
+	}
+	defer a.mutex.Unlock()
+
+	if a.token == "" {
+		return
+	}
+	if len(a.pending) >= a.gate.ResumeMsgNum { //缓存满了，客户端已经无法恢复
+		log.Debug("too many messages while disconnected, session ended")
+		a.end()
+		return
+	}
+	a.pending = append(a.pending, pendingMsg{args, priority})
 }
 
 //实现代理接口(gate.Agent)Close函数
-//关闭代理
+//关闭代理，会话随之结束
 func (a *TCPAgent) Close() {
-	a.conn.Close() //关闭连接
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.close(network.CloseKicked)
+}
+
+//以reason关闭代理，调用前需要加锁
+func (a *TCPAgent) close(reason network.CloseReason) {
+	a.setReason(reason)
+	if a.conn != nil {
+		a.conn.SetCloseReason(a.reason)
+		a.closed = true
+		a.conn.Close() //关闭连接，由连接的OnClose调用CloseAgent
+		return
+	}
+	a.end()
+}
+
+//结束断线期间的会话，在其它goroutine中调用CloseAgent，调用前需要加锁
+func (a *TCPAgent) end() {
+	if a.closed {
+		return
+	}
+	a.closed = true
+	a.pending = nil
+	a.replay = nil
+	if a.expire != nil && a.expire.Stop() {
+		a.gate.wg.Done()
+	}
+	a.expire = nil
+
+	a.gate.wg.Add(1)
+	go func() {
+		defer a.gate.wg.Done()
+		a.gate.closeAgent(a)
+	}()
+}
+
+//记录会话结束的原因，只记录第一个原因，调用前需要加锁
+func (a *TCPAgent) setReason(reason network.CloseReason) {
+	if a.reason == network.CloseUnknown {
+		a.reason = reason
+	}
+}
+
+//服务器关闭，发送CloseMsg后关闭代理
+func (a *TCPAgent) closeWithMsg() {
+	a.mutex.Lock()
+	a.setReason(network.CloseServerShutdown)
+	a.mutex.Unlock()
+
+	if a.gate.CloseMsg != nil {
+		a.WriteMsgPriority(a.gate.CloseMsg, network.PriorityCritical)
+	}
+	a.Close()
+}
+
+// goroutine safe
+//创建会话，返回会话令牌，一般在登录成功后调用并把令牌发送给客户端
+//连接断开后，会话保留ResumeTimeout时间，期间新的连接发送ResumeMsg即可绑定到这个代理上
+//ResumeTimeout为0时返回空字符串，再次调用会使原来的令牌失效
+func (a *TCPAgent) NewSession() string {
+	if a.gate.ResumeTimeout <= 0 {
+		return ""
+	}
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Error("generate session token error: %v", err)
+		return ""
+	}
+	token := hex.EncodeToString(b)
+
+	a.gate.mutexAgents.Lock()
+	defer a.gate.mutexAgents.Unlock()
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.closed {
+		return ""
+	}
+	if a.gate.sessions == nil {
+		a.gate.sessions = make(map[string]*TCPAgent)
+	}
+	if a.token != "" {
+		delete(a.gate.sessions, a.token)
+	}
+	a.token = token
+	a.gate.sessions[token] = a
+	return token
+}
+
+//断线后会话超时
+func (a *TCPAgent) timeout(detach int) {
+	defer a.gate.wg.Done()
+
+	a.mutex.Lock()
+	if a.closed || a.conn != nil || a.detach != detach { //已经重连或者结束
+		a.mutex.Unlock()
+		return
+	}
+	a.closed = true
+	a.pending = nil
+	a.replay = nil
+	a.expire = nil
+	a.mutex.Unlock()
+
+	a.gate.closeAgent(a)
+}
+
+//实现代理接口(gate.Agent)CloseReason函数
+//会话结束或者断线等待重连时的原因
+func (a *TCPAgent) CloseReason() network.CloseReason {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.reason
 }
 
 //实现代理接口(gate.Agent)UserData函数
@@ -148,3 +582,164 @@ func (a *TCPAgent) UserData() interface{} {
 func (a *TCPAgent) SetUserData(data interface{}) {
 	a.userData = data
 }
+
+//连接的代理，实现了network.Agent，每个连接一个
+//读取的消息交给当前绑定的TCPAgent处理
+type connAgent struct {
+	conn  network.Conn //连接
+	gate  *TCPGate     //TCP网关
+	agent *TCPAgent    //绑定的代理，断线重连后改为原来的代理
+
+	announced bool //agent已经调用了NewAgent，只在连接的goroutine中使用
+
+	// rate limit
+	msgBucket   tokenBucket                   //消息数
+	byteBucket  tokenBucket                   //字节数
+	typeBuckets map[reflect.Type]*tokenBucket //消息类型->消息数
+}
+
+//实现代理接口(network.Agent)Run函数
+func (a *connAgent) Run() {
+	for {
+		data, err := a.conn.ReadMsg() //读取一条完整的消息
+		if err != nil {
+			log.Debug("read message error: %v, %v", err, a.conn.CloseReason())
+			break
+		}
+
+		err = a.gate.route(data, a)
+		a.conn.ReleaseMsg(data) //解码后不再使用，放回缓冲区池
+		if err != nil {
+			log.Debug("%v", err)
+			break
+		}
+	}
+}
+
+//实现代理接口(network.Agent)OnClose函数
+//连接断开时，有会话的代理保留ResumeTimeout时间，否则调用CloseAgent
+func (a *connAgent) OnClose() {
+	a.gate.mutexAgents.Lock()
+	closing := a.gate.closing
+	a.gate.mutexAgents.Unlock()
+
+	agent := a.agent
+	agent.mutex.Lock()
+	if agent.conn != a.conn { //代理已经绑定到新的连接上
+		agent.mutex.Unlock()
+		return
+	}
+	agent.conn = nil
+	agent.setReason(a.conn.CloseReason())               //连接记录的原因
+	if !agent.closed && agent.token != "" && !closing { //网关正在关闭时不再保留会话
+		agent.detach++
+		detach := agent.detach
+		agent.gate.wg.Add(1)
+		agent.expire = time.AfterFunc(agent.gate.ResumeTimeout, func() {
+			agent.timeout(detach)
+		})
+		agent.mutex.Unlock()
+		return
+	}
+	agent.closed = true
+	agent.mutex.Unlock()
+
+	a.gate.closeAgent(agent)
+}
+
+//为连接的代理调用NewAgent
+//支持断线重连时，只重连的连接不会为临时的代理调用NewAgent和CloseAgent
+func (a *connAgent) announce() {
+	a.announced = true
+	a.agent.mutex.Lock()
+	a.agent.announced = true
+	a.agent.mutex.Unlock()
+
+	if a.gate.AgentChanRPC != nil { //代理RPC服务器，用于接受NewAgent和CloseAgentRPC调用
+		a.gate.AgentChanRPC.Go("NewAgent", a.agent)
+	}
+}
+
+//处理断线重连的消息，msg不是ResumeMsg时返回false
+//只有还没有创建会话的连接可以重连，成功后连接绑定到令牌对应的代理上，断线期间缓存的消息随后发送
+//开启可靠传输时，ack为客户端最后收到的消息序号，之后的消息都会重发
+func (a *connAgent) resume(msg interface{}, ack uint32) bool {
+	m, ok := msg.(ResumeMsg)
+	if !ok || a.gate.ResumeTimeout <= 0 {
+		return false
+	}
+
+	agent := a.bind(m.ResumeToken(), ack, m)
+	if agent == nil { //重连失败，继续使用当前的代理
+		if reply := m.ResumeReply(false); reply != nil {
+			a.agent.mutex.Lock()
+			a.agent.writeControl(reply)
+			a.agent.mutex.Unlock()
+		}
+		return true
+	}
+
+	//当前的代理已经没有用了，已经调用过NewAgent的话，CloseAgent的原因为CloseResumed
+	old := a.agent
+	a.agent = agent
+	a.announced = true //原来的代理已经调用过NewAgent
+	old.mutex.Lock()
+	old.conn = nil
+	old.closed = true
+	old.setReason(network.CloseResumed)
+	old.mutex.Unlock()
+	a.gate.closeAgent(old)
+	return true
+}
+
+//把连接绑定到令牌对应的代理上，发送回复和缓存的消息，失败返回nil
+func (a *connAgent) bind(token string, ack uint32, m ResumeMsg) *TCPAgent {
+	a.gate.mutexAgents.Lock()
+	agent := a.gate.sessions[token]
+	a.gate.mutexAgents.Unlock()
+	if agent == nil || agent == a.agent {
+		return nil
+	}
+
+	//当前的代理已经有会话，不能再绑定到其它会话上
+	a.agent.mutex.Lock()
+	hasSession := a.agent.token != ""
+	a.agent.mutex.Unlock()
+	if hasSession {
+		return nil
+	}
+
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+	if agent.closed {
+		return nil
+	}
+
+	oldConn := agent.conn
+	agent.conn = a.conn
+	agent.reason = network.CloseUnknown //之前断线的原因不再有效
+	if agent.expire != nil && agent.expire.Stop() {
+		agent.gate.wg.Done()
+	}
+	agent.expire = nil
+
+	if reply := m.ResumeReply(true); reply != nil {
+		agent.writeControl(reply)
+	}
+	if a.gate.Reliable {
+		agent.acked(ack)
+		for _, r := range agent.replay { //重发客户端没有收到的消息
+			agent.send(r.seq, r.args, r.priority)
+		}
+	} else {
+		for _, p := range agent.pending {
+			a.conn.WriteMsgPriority(p.priority, p.args...)
+		}
+		agent.pending = nil
+	}
+
+	if oldConn != nil { //服务器还没有发现原来的连接断开，直接关闭，其OnClose不会再处理代理
+		oldConn.Close()
+	}
+	return agent
+}
@@ -96,6 +96,12 @@ func (g *Go) Close() {
 	}
 }
 
+// goroutine not safe
+//待处理的回调函数数目
+func (g *Go) Pending() int {
+	return g.pendingGo
+}
+
 //创建线性上下文
 func (g *Go) NewLinearContext() *LinearContext {
 	c := new(LinearContext) //创建一个线性上下文
@@ -1,18 +1,22 @@
 package leaf
 
 import (
+	"github.com/name5566/leaf/cluster"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/console"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
 	"github.com/name5566/leaf/module"
+	"io"
 	"os"
 	"os/signal"
+	"syscall"
 )
 
 func Run(mods ...module.Module) { //...不定参数语法，参数类型都为module.Module
 	// logger
 	if conf.LogLevel != "" { //日志级别不为空
-		logger, err := log.New(conf.LogLevel, conf.LogPath) //创建一个logger
+		logger, err := newLogger() //创建一个logger
 		if err != nil {
 			panic(err)
 		}
@@ -22,20 +26,67 @@ func Run(mods ...module.Module) { //...不定参数语法，参数类型都为mo
 
 	log.Release("Leaf starting up") //关键日志
 
+	// metrics
+	if conf.MetricsAddr != "" { //开启统计
+		err := metrics.Start(conf.MetricsAddr)
+		if err != nil {
+			panic(err)
+		}
+		defer metrics.Close()
+	}
+
 	// module
 	for i := 0; i < len(mods); i++ { //遍历传入的所有module
 		module.Register(mods[i]) //注册module
 	}
 	module.Init() //初始化模块，并执行各个模块(在各个不同的goroutine里)
 
+	// cluster
+	cluster.Init() //初始化集群，接受其它节点的chanrpc调用
+
 	// console
 	console.Init() //初始化控制台
 
 	// close
 	c := make(chan os.Signal, 1)                       //新建一个管道用于接收系统Signal
-	signal.Notify(c, os.Interrupt, os.Kill)            //监听SIGINT和SIGKILL信号(linux下叫这个名字)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)    //监听SIGINT和SIGTERM信号(SIGKILL无法捕获)
 	sig := <-c                                         //读信号，没有信号时会阻塞goroutine
 	log.Release("Leaf closing down (signal: %v)", sig) //关键日志 服务器关闭
 	console.Destroy()                                  //销毁控制台
-	module.Destroy()                                   //销毁模块
+	module.Destroy()                                   //销毁模块，模块处理完排队的调用前还可以发起和接收远程调用
+	cluster.Destroy()                                  //销毁集群
+}
+
+//根据conf创建logger
+func newLogger() (*log.Logger, error) {
+	enc, err := log.NewEncoder(conf.LogFormat) //日志格式
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.Writer = os.Stdout
+	if conf.LogPath != "" { //写入文件
+		file := &log.RotateFile{
+			Path:       conf.LogPath,
+			MaxSize:    conf.LogMaxSize,
+			RotateTime: conf.LogRotateTime,
+			MaxBackups: conf.LogMaxBackups,
+			MaxAge:     conf.LogMaxAge,
+			Compress:   conf.LogCompress,
+		}
+		err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		file.ReopenOnSignal(syscall.SIGHUP) //收到SIGHUP时创建新的日志文件
+		w = file
+	}
+
+	h := log.NewWriterHandler(w, enc)
+	logger, err := log.NewLogger(conf.LogLevel, h)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	return logger, nil
 }
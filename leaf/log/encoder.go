@@ -0,0 +1,118 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//文本编码器，和原来的输出格式一致
+//2006/01/02 15:04:05 [release] name: msg key=value
+type TextEncoder struct{}
+
+func (TextEncoder) Encode(buf []byte, r *Record) []byte {
+	buf = r.Time.AppendFormat(buf, "2006/01/02 15:04:05 ")
+	buf = append(buf, r.Level.prefix()...)
+	if r.Name != "" {
+		buf = append(buf, r.Name...)
+		buf = append(buf, ": "...)
+	}
+	buf = append(buf, r.Msg...)
+	buf = appendLogfmtFields(buf, r.Fields)
+	if len(buf) == 0 || buf[len(buf)-1] != '\n' {
+		buf = append(buf, '\n')
+	}
+	return buf
+}
+
+//JSON编码器，每条日志一行
+//{"time":"...","level":"release","name":"...","msg":"...","key":value}
+type JSONEncoder struct{}
+
+func (JSONEncoder) Encode(buf []byte, r *Record) []byte {
+	buf = append(buf, `{"time":"`...)
+	buf = r.Time.AppendFormat(buf, time.RFC3339Nano)
+	buf = append(buf, `","level":`...)
+	buf = strconv.AppendQuote(buf, r.Level.String())
+	if r.Name != "" {
+		buf = append(buf, `,"name":`...)
+		buf = appendJSON(buf, r.Name)
+	}
+	buf = append(buf, `,"msg":`...)
+	buf = appendJSON(buf, r.Msg)
+	for i := 0; i < len(r.Fields); i += 2 {
+		buf = append(buf, ',')
+		buf = appendJSON(buf, fieldKey(r.Fields[i]))
+		buf = append(buf, ':')
+		buf = appendJSON(buf, fieldValue(r.Fields, i+1))
+	}
+	buf = append(buf, "}\n"...)
+	return buf
+}
+
+//logfmt编码器
+//time=... level=release name=... msg="..." key=value
+type LogfmtEncoder struct{}
+
+func (LogfmtEncoder) Encode(buf []byte, r *Record) []byte {
+	buf = append(buf, "time="...)
+	buf = r.Time.AppendFormat(buf, time.RFC3339Nano)
+	buf = append(buf, " level="...)
+	buf = append(buf, r.Level.String()...)
+	if r.Name != "" {
+		buf = append(buf, " name="...)
+		buf = appendLogfmtValue(buf, r.Name)
+	}
+	buf = append(buf, " msg="...)
+	buf = appendLogfmtValue(buf, r.Msg)
+	buf = appendLogfmtFields(buf, r.Fields)
+	buf = append(buf, '\n')
+	return buf
+}
+
+//键值对中的key
+func fieldKey(k interface{}) string {
+	if s, ok := k.(string); ok {
+		return s
+	}
+	return fmt.Sprint(k)
+}
+
+//键值对中的value，个数为奇数时最后一个key的value为"MISSING"
+func fieldValue(fields []interface{}, i int) interface{} {
+	if i >= len(fields) {
+		return "MISSING"
+	}
+	v := fields[i]
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+	return v
+}
+
+func appendJSON(buf []byte, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return append(buf, data...)
+}
+
+func appendLogfmtFields(buf []byte, fields []interface{}) []byte {
+	for i := 0; i < len(fields); i += 2 {
+		buf = append(buf, ' ')
+		buf = append(buf, fieldKey(fields[i])...)
+		buf = append(buf, '=')
+		buf = appendLogfmtValue(buf, fmt.Sprint(fieldValue(fields, i+1)))
+	}
+	return buf
+}
+
+func appendLogfmtValue(buf []byte, s string) []byte {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.AppendQuote(buf, s)
+	}
+	return append(buf, s...)
+}
@@ -1,7 +1,13 @@
 package log_test
 
 import (
+	"errors"
+	"fmt"
 	"github.com/name5566/leaf/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
 )
 
 func Example() {
@@ -26,3 +32,106 @@ func Example() {
 	log.Debug("will not print")
 	log.Release("My name is %v", name)
 }
+
+//自定义的日志处理器
+type printHandler struct{}
+
+func (printHandler) Handle(r *log.Record) {
+	fmt.Println(r.Level, r.Name, r.Msg, r.Fields)
+}
+
+func ExampleNewLogger() {
+	logger, err := log.NewLogger("debug", printHandler{})
+	if err != nil {
+		return
+	}
+
+	game := logger.Named("game")
+	game.Release("user login")
+	game.With("userID", 1001, "err", errors.New("timeout")).Error("save failed")
+
+	// Output:
+	// release game user login []
+	// error game save failed [userID 1001 err timeout]
+}
+
+func ExampleJSONEncoder() {
+	r := &log.Record{
+		Time:   time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Level:  log.Level(2),
+		Name:   "game",
+		Msg:    "save failed",
+		Fields: []interface{}{"userID", 1001, "err", errors.New("db timeout")},
+	}
+
+	fmt.Print(string(log.JSONEncoder{}.Encode(nil, r)))
+	fmt.Print(string(log.LogfmtEncoder{}.Encode(nil, r)))
+	fmt.Print(string(log.TextEncoder{}.Encode(nil, r)))
+
+	// Output:
+	// {"time":"2000-01-01T00:00:00Z","level":"error","name":"game","msg":"save failed","userID":1001,"err":"db timeout"}
+	// time=2000-01-01T00:00:00Z level=error name=game msg="save failed" userID=1001 err="db timeout"
+	// 2000/01/01 00:00:00 [error  ] game: save failed userID=1001 err="db timeout"
+}
+
+func ExampleRotateFile() {
+	dir, err := ioutil.TempDir("", "leaf_log")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	file := &log.RotateFile{
+		Path:       dir,
+		MaxSize:    64,
+		MaxBackups: 2,
+		Compress:   true,
+	}
+	err = file.Open()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	logger, err := log.NewLogger("debug", log.NewWriterHandler(file, log.LogfmtEncoder{}))
+	if err != nil {
+		return
+	}
+	for i := 0; i < 10; i++ {
+		logger.Release("My name is Leaf")
+	}
+	logger.Close()
+
+	//当前文件和两个压缩过的旧文件
+	fis, _ := ioutil.ReadDir(dir)
+	gz := 0
+	for _, fi := range fis {
+		if filepath.Ext(fi.Name()) == ".gz" {
+			gz++
+		}
+	}
+	fmt.Println(len(fis), gz)
+
+	// Output:
+	// 3 2
+}
+
+func ExampleSetNamedLevel() {
+	logger, err := log.NewLogger("release", printHandler{})
+	if err != nil {
+		return
+	}
+
+	db := logger.Named("db")
+	db.Debug("will not print")
+
+	log.SetNamedLevel("db", "debug")
+	db.Debug("query users")
+	logger.Debug("will not print")
+
+	log.SetNamedLevel("db", "")
+	db.Debug("will not print")
+
+	// Output:
+	// debug db query users []
+}
@@ -0,0 +1,99 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+//一条日志记录
+type Record struct {
+	Time   time.Time     //时间
+	Level  Level         //级别
+	Name   string        //logger的名字，可以为空
+	Msg    string        //格式化后的消息
+	Fields []interface{} //键值对，key, value, key, value...
+}
+
+//日志处理器接口，实现此接口即可接入自己的日志输出
+//Handle可能在多个goroutine中同时调用，需要goroutine safe
+//如果同时实现了io.Closer，Logger.Close时会调用Close
+type Handler interface {
+	Handle(r *Record)
+}
+
+//编码器接口，把日志记录编码后追加到buf中
+type Encoder interface {
+	Encode(buf []byte, r *Record) []byte
+}
+
+//根据名字创建编码器，"text"(默认)、"json"或者"logfmt"
+func NewEncoder(format string) (Encoder, error) {
+	switch format {
+	case "", "text":
+		return TextEncoder{}, nil
+	case "json":
+		return JSONEncoder{}, nil
+	case "logfmt":
+		return LogfmtEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %v", format)
+	}
+}
+
+//写入io.Writer的日志处理器
+type WriterHandler struct {
+	mutex sync.Mutex //互斥锁，保证每条日志完整写入
+	w     io.Writer  //输出
+	enc   Encoder    //编码器
+	buf   []byte     //编码缓冲
+}
+
+//创建写入w的日志处理器
+func NewWriterHandler(w io.Writer, enc Encoder) *WriterHandler {
+	h := new(WriterHandler)
+	h.w = w
+	h.enc = enc
+	return h
+}
+
+// goroutine safe
+func (h *WriterHandler) Handle(r *Record) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.buf = h.enc.Encode(h.buf[:0], r)
+	h.w.Write(h.buf)
+}
+
+//关闭输出，标准输出和标准错误不会被关闭
+func (h *WriterHandler) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.w == os.Stdout || h.w == os.Stderr {
+		return nil
+	}
+	if c, ok := h.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+//在目录pathname下创建一个以当前时间命名的日志文件
+func CreateFile(pathname string) (*os.File, error) {
+	now := time.Now()
+
+	filename := fmt.Sprintf("%d%02d%02d_%02d_%02d_%02d.log", //文件名,时间命名
+		now.Year(),
+		now.Month(),
+		now.Day(),
+		now.Hour(),
+		now.Minute(),
+		now.Second())
+
+	return os.Create(path.Join(pathname, filename)) //创建文件
+}
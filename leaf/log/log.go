@@ -3,20 +3,25 @@ package log
 import (
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // levels
 //日志级别定义
+type Level int
+
 const (
-	debugLevel   = 0 //非关键日志
-	releaseLevel = 1 //关键日志
-	errorLevel   = 2 //错误日志
-	fatalLevel   = 3 //致命错误日志。Fatal 日志比较特殊，每次输出 Fatal 日志之后游戏服务器进程就会结束
+	debugLevel   Level = 0 //非关键日志
+	releaseLevel Level = 1 //关键日志
+	errorLevel   Level = 2 //错误日志
+	fatalLevel   Level = 3 //致命错误日志。Fatal 日志比较特殊，每次输出 Fatal 日志之后游戏服务器进程就会结束
 )
 
 //Debug < Release < Error < Fatal（日志级别高低）
@@ -29,122 +34,293 @@ const (
 	printFatalLevel   = "[fatal  ] "
 )
 
-//上层Logger定义
-type Logger struct {
-	level      int         //日志级别
-	baseLogger *log.Logger //底层logger,基于go的log包
-	baseFile   *os.File    //日志写入的文件
-}
-
-func New(strLevel string, pathname string) (*Logger, error) { //上层logger创建函数
-	// level
-	var level int
+//解析日志级别
+func ParseLevel(strLevel string) (Level, error) {
 	switch strings.ToLower(strLevel) { //根据传入的日志级别，设置日志级别
 	case "debug":
-		level = debugLevel
+		return debugLevel, nil
 	case "release":
-		level = releaseLevel
+		return releaseLevel, nil
 	case "error":
-		level = errorLevel
+		return errorLevel, nil
 	case "fatal":
-		level = fatalLevel
+		return fatalLevel, nil
 	default:
-		return nil, errors.New("unknown level: " + strLevel)
+		return 0, errors.New("unknown level: " + strLevel)
 	}
+}
 
-	// logger
-	var baseLogger *log.Logger
-	var baseFile *os.File
-	if pathname != "" { //写入文件路径名
-		now := time.Now()
+func (level Level) String() string {
+	switch level {
+	case debugLevel:
+		return "debug"
+	case releaseLevel:
+		return "release"
+	case errorLevel:
+		return "error"
+	case fatalLevel:
+		return "fatal"
+	default:
+		return "level(" + strconv.Itoa(int(level)) + ")"
+	}
+}
 
-		filename := fmt.Sprintf("%d%02d%02d_%02d_%02d_%02d.log", //文件名,时间命名
-			now.Year(),
-			now.Month(),
-			now.Day(),
-			now.Hour(),
-			now.Minute(),
-			now.Second())
+//文本格式的前缀
+func (level Level) prefix() string {
+	switch level {
+	case debugLevel:
+		return printDebugLevel
+	case releaseLevel:
+		return printReleaseLevel
+	case errorLevel:
+		return printErrorLevel
+	default:
+		return printFatalLevel
+	}
+}
+
+//输出Fatal日志后调用，可以替换以便在退出前做清理工作
+var ExitFunc = os.Exit
+
+//上层Logger定义
+//With和Named返回的logger与原logger共用同一个处理器和日志级别
+type Logger struct {
+	level     int32         //日志级别，运行时可以修改，原子操作
+	handler   Handler       //日志处理器
+	name      string        //名字
+	nameLevel *int32        //按名字设置的日志级别，小于0时使用level
+	fields    []interface{} //附加的键值对
+	global    bool          //使用全局logger(gLogger)的处理器和日志级别，Export之后依然有效
+	root      *Logger       //共用处理器和日志级别的logger
+}
 
-		file, err := os.Create(path.Join(pathname, filename)) //创建文件
+func New(strLevel string, pathname string) (*Logger, error) { //上层logger创建函数
+	// logger
+	var h Handler
+	if pathname != "" { //写入文件路径名
+		file, err := CreateFile(pathname) //创建文件
 		if err != nil {
 			return nil, err
 		}
-
-		baseLogger = log.New(file, "", log.LstdFlags) //创建logger(底层的)，LstdFlags只显示日期和时间
-		baseFile = file                               //保存文件引用
+		h = NewWriterHandler(file, TextEncoder{})
 	} else {
-		baseLogger = log.New(os.Stdout, "", log.LstdFlags) //输出log到标准输出
+		h = NewWriterHandler(os.Stdout, TextEncoder{}) //输出log到标准输出
+	}
+
+	logger, err := NewLogger(strLevel, h)
+	if err != nil {
+		h.(*WriterHandler).Close()
+		return nil, err
+	}
+	return logger, nil
+}
+
+//使用自定义的日志处理器创建logger
+func NewLogger(strLevel string, handler Handler) (*Logger, error) {
+	// level
+	level, err := ParseLevel(strLevel)
+	if err != nil {
+		return nil, err
 	}
 
 	// new
 	//创建上层logger
 	logger := new(Logger)
 	//设置字段值
-	logger.level = level           //日志级别字段
-	logger.baseLogger = baseLogger //底层logger
-	logger.baseFile = baseFile     //文件引用
+	logger.level = int32(level) //日志级别字段
+	logger.handler = handler    //日志处理器
+	logger.root = logger
 
 	return logger, nil
 }
 
+//返回附加了键值对的logger，kv为key, value, key, value...
+func (logger *Logger) With(kv ...interface{}) *Logger {
+	l := *logger
+	l.fields = make([]interface{}, 0, len(logger.fields)+len(kv))
+	l.fields = append(l.fields, logger.fields...)
+	l.fields = append(l.fields, kv...)
+	return &l
+}
+
+//返回指定名字的logger，一般每个模块使用一个，名字会出现在每条日志中
+func (logger *Logger) Named(name string) *Logger {
+	l := *logger
+	if logger.name != "" {
+		l.name = logger.name + "." + name
+	} else {
+		l.name = name
+	}
+	l.nameLevel = namedLevel(l.name)
+	return &l
+}
+
+//当前的日志级别，按名字设置过的优先
+func (logger *Logger) Level() Level {
+	if logger.nameLevel != nil {
+		if level := atomic.LoadInt32(logger.nameLevel); level >= 0 {
+			return Level(level)
+		}
+	}
+	return Level(atomic.LoadInt32(&logger.base().level))
+}
+
+// goroutine safe
+//修改日志级别，共用同一个处理器的logger都会受影响
+func (logger *Logger) SetLevel(strLevel string) error {
+	level, err := ParseLevel(strLevel)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&logger.base().level, int32(level))
+	return nil
+}
+
+//按名字设置的日志级别，名字->级别(小于0表示使用全局级别)
+var (
+	mutexNamedLevels sync.Mutex
+	namedLevels      = make(map[string]*int32)
+)
+
+//取得名字对应的日志级别，没有则创建
+func namedLevel(name string) *int32 {
+	mutexNamedLevels.Lock()
+	defer mutexNamedLevels.Unlock()
+
+	level, ok := namedLevels[name]
+	if !ok {
+		level = new(int32)
+		*level = -1
+		namedLevels[name] = level
+	}
+	return level
+}
+
+// goroutine safe
+//设置指定名字的logger的日志级别，strLevel为空则恢复使用全局级别
+//可以在创建对应名字的logger之前设置
+func SetNamedLevel(name string, strLevel string) error {
+	level := int32(-1)
+	if strLevel != "" {
+		l, err := ParseLevel(strLevel)
+		if err != nil {
+			return err
+		}
+		level = int32(l)
+	}
+	atomic.StoreInt32(namedLevel(name), level)
+	return nil
+}
+
+// goroutine safe
+//指定名字的logger的日志级别，没有单独设置过的返回false
+func NamedLevel(name string) (Level, bool) {
+	level := atomic.LoadInt32(namedLevel(name))
+	if level < 0 {
+		return 0, false
+	}
+	return Level(level), true
+}
+
+// goroutine safe
+//所有创建过的logger的名字，按字母排序
+func Names() []string {
+	mutexNamedLevels.Lock()
+	defer mutexNamedLevels.Unlock()
+
+	names := make([]string, 0, len(namedLevels))
+	for name := range namedLevels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//共用处理器和日志级别的logger
+func (logger *Logger) base() *Logger {
+	if logger.global {
+		return gLogger
+	}
+	return logger.root
+}
+
 // It's dangerous to call the method on logging
 func (logger *Logger) Close() {
-	if logger.baseFile != nil { //写入文件存在
-		logger.baseFile.Close() //关闭文件
+	base := logger.base()
+	if c, ok := base.handler.(io.Closer); ok { //处理器需要关闭
+		c.Close()
 	}
 	//置空字段
-	logger.baseLogger = nil
-	logger.baseFile = nil
+	base.handler = nil
 }
 
 //最终调用的日志输出函数
-func (logger *Logger) doPrintf(level int, printLevel string, format string, a ...interface{}) {
-	if level < logger.level { //日志级别小于设定的日志级别
+func (logger *Logger) doPrintf(level Level, format string, a ...interface{}) {
+	if level < logger.Level() { //日志级别小于设定的日志级别
 		return //不输出
 	}
-	//底层logger为空
-	if logger.baseLogger == nil {
+	base := logger.base()
+	//处理器为空
+	if base.handler == nil {
 		panic("logger closed") //抛出一个异常，在defer中通过recover可以捕获异常
 	}
 
-	format = printLevel + format           //前缀+格式
-	logger.baseLogger.Printf(format, a...) //输出日志
+	r := Record{
+		Time:   time.Now(),
+		Level:  level,
+		Name:   logger.name,
+		Msg:    fmt.Sprintf(format, a...),
+		Fields: logger.fields,
+	}
+	base.handler.Handle(&r) //输出日志
 
 	if level == fatalLevel { //如果为fatal日志
-		os.Exit(1) //退出程序
+		ExitFunc(1) //退出程序
 	}
 }
 
 //不同级别的日志函数
 func (logger *Logger) Debug(format string, a ...interface{}) {
-	logger.doPrintf(debugLevel, printDebugLevel, format, a...)
+	logger.doPrintf(debugLevel, format, a...)
 }
 
 func (logger *Logger) Release(format string, a ...interface{}) {
-	logger.doPrintf(releaseLevel, printReleaseLevel, format, a...)
+	logger.doPrintf(releaseLevel, format, a...)
 }
 
 func (logger *Logger) Error(format string, a ...interface{}) {
-	logger.doPrintf(errorLevel, printErrorLevel, format, a...)
+	logger.doPrintf(errorLevel, format, a...)
 }
 
 func (logger *Logger) Fatal(format string, a ...interface{}) {
-	logger.doPrintf(fatalLevel, printFatalLevel, format, a...)
+	logger.doPrintf(fatalLevel, format, a...)
 }
 
 //创建一个默认的logger，日志级别为debug，使用者就可以不用自己定义logger，而是直接引入包，使用包导出函数即可。
 var gLogger, _ = New("debug", "")
 
+//跟随gLogger的logger，用于包级的With和Named
+var globalLogger = &Logger{global: true}
+
 //包级导出日志函数
 // It's dangerous to call the method on logging
 //导出函数定义，传入一个logger,替换默认的gLogger
 func Export(logger *Logger) {
-	if logger != nil {
+	if logger != nil && !logger.global {
 		gLogger = logger
 	}
 }
 
+//返回附加了键值对的logger，总是使用当前的全局logger输出
+func With(kv ...interface{}) *Logger {
+	return globalLogger.With(kv...)
+}
+
+//返回指定名字的logger，总是使用当前的全局logger输出，可以在包初始化时调用
+func Named(name string) *Logger {
+	return globalLogger.Named(name)
+}
+
 func Debug(format string, a ...interface{}) {
 	gLogger.Debug(format, a...)
 }
@@ -161,6 +337,17 @@ func Fatal(format string, a ...interface{}) {
 	gLogger.Fatal(format, a...)
 }
 
+//全局logger的日志级别
+func GetLevel() Level {
+	return gLogger.Level()
+}
+
+// goroutine safe
+//修改全局logger的日志级别
+func SetLevel(strLevel string) error {
+	return gLogger.SetLevel(strLevel)
+}
+
 func Close() {
 	gLogger.Close()
 }
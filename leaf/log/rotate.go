@@ -0,0 +1,280 @@
+package log
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//日志文件名，和CreateFile一致，同一秒内创建多个文件时加上序号
+var logFileName = regexp.MustCompile(`^\d{8}_\d{2}_\d{2}_\d{2}(_\d+)?\.log(\.gz)?$`)
+
+//可切分的日志文件，实现了io.WriteCloser
+//每个文件以创建时间命名，写满MaxSize或者到了下一个小时/天就创建新的文件
+//旧的文件可以压缩，超过MaxBackups个或者MaxAge时间的旧文件会被删除
+type RotateFile struct {
+	Path       string        //日志目录
+	MaxSize    int64         //单个文件最大字节数，为0不按大小切分
+	RotateTime string        //按时间切分，"hour"或者"day"，为空不按时间切分
+	MaxBackups int           //保留的旧文件个数，为0不限
+	MaxAge     time.Duration //旧文件保留的时间，为0不限
+	Compress   bool          //是否用gzip压缩旧文件
+
+	mutex     sync.Mutex     //互斥锁
+	file      *os.File       //当前的文件
+	size      int64          //当前文件的大小
+	next      time.Time      //下一次按时间切分的时间
+	mutexOld  sync.Mutex     //保证旧文件的处理串行执行
+	wg        sync.WaitGroup //等待旧文件处理完成
+	signalSig chan os.Signal //重新打开文件的信号
+}
+
+//打开第一个文件
+func (f *RotateFile) Open() error {
+	switch f.RotateTime {
+	case "", "hour", "day":
+	default:
+		return errors.New("unknown rotate time: " + f.RotateTime)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.open()
+}
+
+//创建新的文件，调用前需要加锁
+func (f *RotateFile) open() error {
+	now := time.Now()
+	name := fmt.Sprintf("%d%02d%02d_%02d_%02d_%02d", //文件名,时间命名
+		now.Year(),
+		now.Month(),
+		now.Day(),
+		now.Hour(),
+		now.Minute(),
+		now.Second())
+
+	var file *os.File
+	var err error
+	for i := 0; ; i++ {
+		filename := name + ".log"
+		if i > 0 {
+			filename = fmt.Sprintf("%v_%d.log", name, i)
+		}
+		file, err = os.OpenFile(path.Join(f.Path, filename), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	f.file = file
+	f.size = 0
+	switch f.RotateTime {
+	case "hour":
+		f.next = time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, now.Location())
+	case "day":
+		f.next = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	}
+
+	return nil
+}
+
+//关闭当前文件，创建新的文件，并在后台处理旧文件，调用前需要加锁
+func (f *RotateFile) rotate() error {
+	old := f.file
+	err := f.open()
+	if err != nil {
+		return err
+	}
+	old.Close()
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.mutexOld.Lock()
+		defer f.mutexOld.Unlock()
+		f.handleOld(old.Name())
+	}()
+
+	return nil
+}
+
+//压缩刚切分出来的文件，删除过多或者过期的旧文件
+//只处理name以及比name更早的文件，更新的文件由之后的切分处理
+func (f *RotateFile) handleOld(name string) {
+	if f.Compress {
+		err := compressFile(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "compress log file %v error: %v\n", name, err)
+		}
+	}
+	if f.MaxBackups <= 0 && f.MaxAge <= 0 {
+		return
+	}
+
+	fis, err := ioutil.ReadDir(f.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read log dir %v error: %v\n", f.Path, err)
+		return
+	}
+
+	var olds []os.FileInfo
+	for _, fi := range fis {
+		if fi.IsDir() || !logFileName.MatchString(fi.Name()) || logFileLess(path.Base(name), fi.Name()) {
+			continue
+		}
+		olds = append(olds, fi)
+	}
+	sort.Slice(olds, func(i, j int) bool { //新的在前
+		return logFileLess(olds[j].Name(), olds[i].Name())
+	})
+
+	now := time.Now()
+	for i, fi := range olds {
+		if f.MaxBackups > 0 && i >= f.MaxBackups ||
+			f.MaxAge > 0 && now.Sub(fi.ModTime()) > f.MaxAge {
+			os.Remove(path.Join(f.Path, fi.Name()))
+		}
+	}
+}
+
+//按创建的先后比较两个日志文件名
+func logFileLess(a, b string) bool {
+	ta, na := logFileOrder(a)
+	tb, nb := logFileOrder(b)
+	if ta != tb {
+		return ta < tb
+	}
+	return na < nb
+}
+
+//日志文件名中的时间和序号
+func logFileOrder(name string) (string, int) {
+	m := logFileName.FindStringSubmatch(name)
+	if m == nil {
+		return name, 0
+	}
+	n := 0
+	if m[1] != "" {
+		n, _ = strconv.Atoi(m[1][1:])
+	}
+	return name[:17], n
+}
+
+//gzip压缩文件，成功后删除原文件
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = dst.Close()
+	} else {
+		dst.Close()
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+
+	return os.Remove(name)
+}
+
+// goroutine safe
+func (f *RotateFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize || //写满了
+		!f.next.IsZero() && !time.Now().Before(f.next) { //到了切分的时间
+		err := f.rotate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rotate log file error: %v\n", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// goroutine safe
+//关闭当前文件并创建一个新的文件，用于配合外部的日志处理工具
+func (f *RotateFile) Reopen() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+//收到sig信号时调用Reopen，Close时停止
+func (f *RotateFile) ReopenOnSignal(sig ...os.Signal) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.signalSig != nil {
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig...)
+	f.signalSig = c
+
+	go func() {
+		for range c {
+			err := f.Reopen()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "reopen log file error: %v\n", err)
+			}
+		}
+	}()
+}
+
+//关闭文件，等待旧文件处理完成
+func (f *RotateFile) Close() error {
+	f.mutex.Lock()
+	if f.signalSig != nil {
+		signal.Stop(f.signalSig)
+		close(f.signalSig)
+		f.signalSig = nil
+	}
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mutex.Unlock()
+
+	f.wg.Wait()
+	return err
+}
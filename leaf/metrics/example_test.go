@@ -0,0 +1,46 @@
+package metrics_test
+
+import (
+	"github.com/name5566/leaf/metrics"
+	"os"
+)
+
+func Example() {
+	logins := metrics.GetCounter("game_logins_total", "Total user logins.", "module", "login")
+	logins.Inc()
+	logins.Add(2)
+
+	online := metrics.GetGauge("game_online_users", "Users currently online.")
+	online.Set(10)
+	online.Dec()
+
+	queue := []int{1, 2, 3}
+	metrics.RegisterGaugeFunc("game_queue_length", "Items in the queue.", func() float64 {
+		return float64(len(queue))
+	})
+
+	h := metrics.GetHistogram("game_save_seconds", "Time spent saving user data.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	metrics.WriteText(os.Stdout)
+
+	// Output:
+	// # HELP game_logins_total Total user logins.
+	// # TYPE game_logins_total counter
+	// game_logins_total{module="login"} 3
+	// # HELP game_online_users Users currently online.
+	// # TYPE game_online_users gauge
+	// game_online_users 9
+	// # HELP game_queue_length Items in the queue.
+	// # TYPE game_queue_length gauge
+	// game_queue_length 3
+	// # HELP game_save_seconds Time spent saving user data.
+	// # TYPE game_save_seconds histogram
+	// game_save_seconds_bucket{le="0.1"} 1
+	// game_save_seconds_bucket{le="1"} 2
+	// game_save_seconds_bucket{le="+Inf"} 3
+	// game_save_seconds_sum 2.55
+	// game_save_seconds_count 3
+}
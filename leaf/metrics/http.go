@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"github.com/name5566/leaf/log"
+	"net"
+	"net/http"
+	"time"
+)
+
+var httpServer *http.Server
+
+//在addr上提供HTTP导出，路径为/metrics，同时开启统计
+func Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	Enable()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteText(w)
+	})
+	httpServer = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		err := httpServer.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			log.Error("metrics http server error: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+//关闭HTTP导出
+func Close() {
+	if httpServer != nil {
+		httpServer.Close()
+		httpServer = nil
+	}
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+//是否开启统计，关闭时框架内置的统计不做任何事情
+var enabled int32
+
+//开启统计，需要在leaf.Run之前调用，或者设置conf.MetricsAddr
+func Enable() {
+	atomic.StoreInt32(&enabled, 1)
+}
+
+// goroutine safe
+//是否开启了统计
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+//原子操作的float64
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+func (f *atomicFloat) store(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		nv := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&f.bits, old, nv) {
+			return
+		}
+	}
+}
+
+// goroutine safe
+//计数器，只增不减
+type Counter struct {
+	v atomicFloat
+}
+
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+//v必须大于等于0
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("counter cannot decrease")
+	}
+	c.v.add(v)
+}
+
+func (c *Counter) Value() float64 {
+	return c.v.load()
+}
+
+// goroutine safe
+//仪表，可增可减
+type Gauge struct {
+	v atomicFloat
+}
+
+func (g *Gauge) Set(v float64) {
+	g.v.store(v)
+}
+
+func (g *Gauge) Add(v float64) {
+	g.v.add(v)
+}
+
+func (g *Gauge) Inc() {
+	g.v.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.v.load()
+}
+
+//默认的直方图区间，单位为秒，适用于处理耗时
+var DefBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// goroutine safe
+//直方图，统计落在各个区间的次数
+type Histogram struct {
+	buckets []float64 //区间上限，从小到大
+	counts  []uint64  //落在各个区间的次数，最后一个为+Inf
+	sum     atomicFloat
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	h := new(Histogram)
+	h.buckets = append([]float64(nil), buckets...)
+	sort.Float64s(h.buckets)
+	h.counts = make([]uint64, len(h.buckets)+1)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v) //第一个大于等于v的区间
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	h.sum.add(v)
+}
+
+//观测的次数
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+//观测值的和
+func (h *Histogram) Sum() float64 {
+	return h.sum.load()
+}
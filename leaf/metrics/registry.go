@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//统计类型
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+//同名统计的集合，不同的标签对应不同的统计
+type family struct {
+	name   string                 //名字
+	help   string                 //说明
+	typ    string                 //类型
+	series map[string]interface{} //标签->*Counter、*Gauge、*Histogram或者func() float64
+}
+
+var (
+	mutex    sync.Mutex
+	families = make(map[string]*family)
+)
+
+//把标签键值对格式化成k1="v1",k2="v2"
+func formatLabels(labels []string) string {
+	if len(labels)%2 != 0 {
+		panic("labels must be key value pairs")
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(labels[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+//获取或者创建统计，调用前需要加锁
+func get(name, help, typ string, labels []string, create func() interface{}) interface{} {
+	f, ok := families[name]
+	if !ok {
+		f = &family{name: name, help: help, typ: typ, series: make(map[string]interface{})}
+		families[name] = f
+	} else if f.typ != typ {
+		panic(fmt.Sprintf("metric %v already registered as %v", name, f.typ))
+	}
+
+	key := formatLabels(labels)
+	m, ok := f.series[key]
+	if !ok {
+		m = create()
+		f.series[key] = m
+	}
+	return m
+}
+
+// goroutine safe
+//获取或者创建计数器，labels为标签的键值对，同样的名字和标签返回同一个计数器
+//频繁使用时应保存返回值，避免每次查找
+func GetCounter(name, help string, labels ...string) *Counter {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return get(name, help, typeCounter, labels, func() interface{} {
+		return new(Counter)
+	}).(*Counter)
+}
+
+// goroutine safe
+//获取或者创建仪表
+func GetGauge(name, help string, labels ...string) *Gauge {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	m, ok := get(name, help, typeGauge, labels, func() interface{} {
+		return new(Gauge)
+	}).(*Gauge)
+	if !ok {
+		panic(fmt.Sprintf("metric %v{%v} is a gauge func", name, formatLabels(labels)))
+	}
+	return m
+}
+
+// goroutine safe
+//注册一个在导出时才求值的仪表，适合队列长度等已有的数据，不导出时没有开销
+//f会在导出的goroutine中调用，需要goroutine safe，同样的名字和标签再次注册会替换原来的f
+func RegisterGaugeFunc(name, help string, f func() float64, labels ...string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	get(name, help, typeGauge, labels, func() interface{} {
+		return f
+	})
+	families[name].series[formatLabels(labels)] = f
+}
+
+// goroutine safe
+//获取或者创建直方图，buckets为nil时使用DefBuckets
+func GetHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return get(name, help, typeHistogram, labels, func() interface{} {
+		if buckets == nil {
+			buckets = DefBuckets
+		}
+		return newHistogram(buckets)
+	}).(*Histogram)
+}
+
+//以Prometheus文本格式输出所有的统计
+func WriteText(w io.Writer) error {
+	mutex.Lock()
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type series struct {
+		labels string
+		m      interface{}
+	}
+	fams := make([]*family, len(names))
+	all := make([][]series, len(names))
+	for i, name := range names {
+		f := families[name]
+		fams[i] = f
+		for labels, m := range f.series {
+			all[i] = append(all[i], series{labels, m})
+		}
+		sort.Slice(all[i], func(a, b int) bool {
+			return all[i][a].labels < all[i][b].labels
+		})
+	}
+	mutex.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for i, f := range fams {
+		name := f.name
+		fmt.Fprintf(bw, "# HELP %v %v\n", name, strings.Replace(f.help, "\n", `\n`, -1))
+		fmt.Fprintf(bw, "# TYPE %v %v\n", name, f.typ)
+
+		for _, s := range all[i] {
+			switch m := s.m.(type) {
+			case *Counter:
+				writeSample(bw, name, s.labels, m.Value())
+			case *Gauge:
+				writeSample(bw, name, s.labels, m.Value())
+			case func() float64:
+				writeSample(bw, name, s.labels, m())
+			case *Histogram:
+				var cumulative uint64
+				for j, upper := range m.buckets {
+					cumulative += atomic.LoadUint64(&m.counts[j])
+					writeSample(bw, name+"_bucket", joinLabels(s.labels, `le="`+formatFloat(upper)+`"`), float64(cumulative))
+				}
+				writeSample(bw, name+"_bucket", joinLabels(s.labels, `le="+Inf"`), float64(m.Count()))
+				writeSample(bw, name+"_sum", s.labels, m.Sum())
+				writeSample(bw, name+"_count", s.labels, float64(m.Count()))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func writeSample(w io.Writer, name, labels string, v float64) {
+	if labels == "" {
+		fmt.Fprintf(w, "%v %v\n", name, formatFloat(v))
+	} else {
+		fmt.Fprintf(w, "%v{%v} %v\n", name, labels, formatFloat(v))
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -3,8 +3,10 @@ package module
 import (
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
+	"reflect"
 	"runtime"
 	"sync"
+	"time"
 )
 
 //模块接口定义
@@ -36,10 +38,16 @@ func Register(mi Module) {
 func Init() {
 	for i := 0; i < len(mods); i++ { //遍历所有注册的模块(从前往后)
 		mods[i].mi.OnInit() //调用各个模块的OnInit函数
+		if s, ok := mods[i].mi.(interface {
+			instrument(name string)
+		}); ok { //使用了Skeleton的模块
+			s.instrument(name(mods[i].mi))
+		}
 	}
 
 	for i := 0; i < len(mods); i++ { //遍历所有注册的模块(从前往后)
-		go run(mods[i]) //在一个新的goroutine中运行模块
+		mods[i].wg.Add(1) //等待goroutine数加1，必须在启动goroutine前调用，否则Destroy可能等不到
+		go run(mods[i])   //在一个新的goroutine中运行模块
 	}
 }
 
@@ -47,15 +55,50 @@ func Init() {
 func Destroy() {
 	for i := len(mods) - 1; i >= 0; i-- { //遍历所有注册的模块(反序，从后往前)
 		m := mods[i]       //取得对应索引的模块
-		m.closeSig <- true //向管道发送关闭信号(导致Run内的死循环介绍，继续执行到m.wg.Done() 60行)
-		m.wg.Wait()        //等待该模块所在goroutine执行完成
-		destroy(m)         //销毁该模块
+		m.closeSig <- true //向管道发送关闭信号(导致Run内的死循环介绍，继续执行到m.wg.Done())
+		if !wait(m) {      //等待该模块所在goroutine执行完成
+			log.Error("module %v close timeout after %v, skip OnDestroy", name(m.mi), conf.ModuleCloseTimeout)
+			continue
+		}
+		destroy(m) //销毁该模块
+	}
+}
+
+//等待模块的Run返回，超过conf.ModuleCloseTimeout返回false
+func wait(m *module) bool {
+	if conf.ModuleCloseTimeout <= 0 {
+		m.wg.Wait()
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(conf.ModuleCloseTimeout):
+		return false
+	}
+}
+
+//模块名字，用于日志
+func name(mi Module) string {
+	t := reflect.TypeOf(mi)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.PkgPath() == "" {
+		return t.String()
 	}
+	return t.PkgPath() + "." + t.Name()
 }
 
 //运行模块函数定义
 func run(m *module) {
-	m.wg.Add(1)          //等待goroutine数加1
 	m.mi.Run(m.closeSig) //调用模块的Run函数(skeleton内实现，一个死循环)
 	m.wg.Done()          //等待goroutine数减1
 }
@@ -13,11 +13,16 @@ import (
 type Skeleton struct {
 	GoLen              int               //Go管道长度
 	TimerDispatcherLen int               //定时器分发器管道长度
+	TimerWheelTick     time.Duration     //时间轮精度，不为0时定时器使用分层时间轮，适合大量定时器
+	AsynCallLen        int               //异步调用返回管道长度
 	ChanRPCServer      *chanrpc.Server   //RPC服务器引用（外部传入）
+	SlowThreshold      time.Duration     //处理单项超过该时间时记录日志，不为0时统计各id的处理耗时
 	g                  *g.Go             //leaf的Go机制
 	dispatcher         *timer.Dispatcher //定时器分发器
 	server             *chanrpc.Server   //RPC服务器引用(内部引用)
+	client             *chanrpc.Client   //RPC客户端，用于向其它RPC服务器发起异步调用
 	commandServer      *chanrpc.Server   //命令RPC服务器引用
+	stat               *skeletonStat     //处理耗时的统计，为nil时不统计
 }
 
 //初始化
@@ -30,15 +35,28 @@ func (s *Skeleton) Init() {
 	if s.TimerDispatcherLen <= 0 {
 		s.TimerDispatcherLen = 0
 	}
+	//检查异步调用返回管道长度
+	if s.AsynCallLen <= 0 {
+		s.AsynCallLen = 0
+	}
 
-	s.g = g.New(s.GoLen)                                     //创建Go
-	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen) //创建分发器
-	s.server = s.ChanRPCServer                               //外部传入的，内部引用
+	s.g = g.New(s.GoLen) //创建Go
+	if s.TimerWheelTick > 0 {
+		s.dispatcher = timer.NewWheelDispatcher(s.TimerDispatcherLen, s.TimerWheelTick) //创建使用时间轮的分发器
+	} else {
+		s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen) //创建分发器
+	}
+	s.client = chanrpc.NewClient(s.AsynCallLen) //创建RPC客户端
+	s.server = s.ChanRPCServer                  //外部传入的，内部引用
 
 	if s.server == nil { //外部传入的为空
 		s.server = chanrpc.NewServer(0) //内部创建一个
 	}
 	s.commandServer = chanrpc.NewServer(0) //创建命令RPC服务器
+
+	if s.SlowThreshold > 0 {
+		s.stat = new(skeletonStat) //开启处理耗时的统计
+	}
 }
 
 //实现了Module接口的Run方法并提供了:
@@ -50,28 +68,74 @@ func (s *Skeleton) Run(closeSig chan bool) {
 	for { //死循环
 		select {
 		case <-closeSig: //读取关闭信号
+			s.drain()               //处理完已经排队的调用和回调
 			s.commandServer.Close() //关闭命令rpc服务器
 			s.server.Close()        //关闭rpc服务器
 			s.g.Close()             //关闭Go
+			s.client.Close()        //执行完所有异步调用的回调
+			s.dispatcher.Close()    //关闭定时器分发器
 			return
 		case ci := <-s.server.ChanCall: //从rpc服务器读取调用信息
-			err := s.server.Exec(ci) //执行调用
-			if err != nil {
-				log.Error("%v", err)
-			}
+			s.exec(s.server, ci) //执行调用
 		case ci := <-s.commandServer.ChanCall: //从命令rpc服务器读取调用信息
-			err := s.commandServer.Exec(ci) //执行命令调用
-			if err != nil {
-				log.Error("%v", err)
-			}
+			s.exec(s.commandServer, ci) //执行命令调用
+		case ri := <-s.client.ChanAsynRet: //从RPC客户端读取异步调用返回
+			start := s.begin()
+			s.client.Cb(ri) //执行异步调用回调
+			s.end(s.funcID("asyncall", ri.Callback()), start)
 		case cb := <-s.g.ChanCb: //从Go的回调管道中读取回调函数
+			start := s.begin()
 			s.g.Cb(cb) //执行回调函数（不用自己写 d.Cb(<-d.ChanCb)了 ）
+			s.end(s.funcID("go", cb), start)
 		case t := <-s.dispatcher.ChanTimer: //从分发器中读取到时定时器
+			start := s.begin()
 			t.Cb() //执行定时器回调
+			s.end(s.funcID("timer", t.Func()), start)
+		}
+	}
+}
+
+//处理完管道中已经排队的RPC调用、命令调用、Go回调和异步调用回调，直到没有可处理的为止
+//之后再关闭，避免关闭时丢弃已经投递的调用
+//已经到期排队的定时器只执行一次，其间重新到期的不再执行，还没有到期的定时器随分发器关闭丢弃，不会执行
+func (s *Skeleton) drain() {
+	for n := len(s.dispatcher.ChanTimer); n > 0; n-- { //重复定时器的间隔很短时不会一直执行下去
+		t := <-s.dispatcher.ChanTimer
+		start := s.begin()
+		t.Cb()
+		s.end(s.funcID("timer", t.Func()), start)
+	}
+
+	for {
+		select {
+		case ci := <-s.server.ChanCall:
+			s.exec(s.server, ci)
+		case ci := <-s.commandServer.ChanCall:
+			s.exec(s.commandServer, ci)
+		case ri := <-s.client.ChanAsynRet:
+			start := s.begin()
+			s.client.Cb(ri)
+			s.end(s.funcID("asyncall", ri.Callback()), start)
+		case cb := <-s.g.ChanCb:
+			start := s.begin()
+			s.g.Cb(cb)
+			s.end(s.funcID("go", cb), start)
+		default:
+			return
 		}
 	}
 }
 
+//执行RPC调用
+func (s *Skeleton) exec(server *chanrpc.Server, ci *chanrpc.CallInfo) {
+	start := s.begin()
+	err := server.Exec(ci)
+	if err != nil {
+		log.Error("%v", err)
+	}
+	s.end(ci.ID(), start)
+}
+
 //注册定时器
 func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
@@ -81,6 +145,15 @@ func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	return s.dispatcher.AfterFunc(d, cb)
 }
 
+//注册重复定时器，回调在模块的goroutine中执行
+func (s *Skeleton) RepeatFunc(d time.Duration, mode timer.RepeatMode, cb func()) *timer.Timer {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.RepeatFunc(d, mode, cb)
+}
+
 //注册cron
 func (s *Skeleton) CronFunc(expr string, cb func()) (*timer.Cron, error) {
 	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
@@ -108,6 +181,17 @@ func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	return s.g.NewLinearContext()
 }
 
+//向server发起异步调用，回调在模块的goroutine中执行
+//server可以是其它模块的RPC服务器，也可以是cluster.NewRemote返回的远程RPC服务器代理
+func (s *Skeleton) AsynCall(server *chanrpc.Server, id interface{}, args ...interface{}) {
+	if s.AsynCallLen == 0 {
+		panic("invalid AsynCallLen")
+	}
+
+	s.client.Attach(server)
+	s.client.AsynCall(id, args...)
+}
+
 //向管道RPC注册函数
 func (s *Skeleton) RegisterChanRPC(id interface{}, f interface{}) {
 	if s.ChanRPCServer == nil { //外部没有传入RPC服务器
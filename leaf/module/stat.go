@@ -0,0 +1,201 @@
+package module
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/console"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//单个id的处理耗时统计
+type itemStat struct {
+	count uint64        //处理次数
+	total time.Duration //总耗时
+	max   time.Duration //最大耗时
+	slow  uint64        //超过SlowThreshold的次数
+}
+
+//模块处理耗时的统计
+type skeletonStat struct {
+	name  string                    //模块名字
+	mutex sync.Mutex                //互斥锁，控制台命令在其它goroutine中读取
+	items map[interface{}]*itemStat //id->处理耗时
+	names map[uintptr]string        //回调函数的地址->统计使用的id，只在模块的goroutine中使用
+
+	// metrics
+	handle    map[interface{}]*metrics.Histogram //id->处理耗时，只在模块的goroutine中使用
+	goPending *metrics.Gauge                     //Go待处理的回调数
+}
+
+//所有开启了统计的骨架
+var (
+	mutexSkeletons sync.Mutex
+	skeletons      []*Skeleton
+)
+
+func init() {
+	console.RegisterFunc("stats", "per-id handling time of modules, usage: stats [reset]", commandStats)
+}
+
+//开启统计，由module.Init调用
+func (s *Skeleton) instrument(name string) {
+	if s.stat == nil && !metrics.Enabled() {
+		return
+	}
+	if s.stat == nil {
+		s.stat = new(skeletonStat)
+	}
+	s.stat.name = name
+
+	mutexSkeletons.Lock()
+	skeletons = append(skeletons, s)
+	mutexSkeletons.Unlock()
+
+	if !metrics.Enabled() {
+		return
+	}
+
+	s.stat.handle = make(map[interface{}]*metrics.Histogram)
+	s.stat.goPending = metrics.GetGauge("leaf_go_pending", "Number of Go callbacks not yet executed.", "module", name)
+
+	server, dispatcher, client := s.server, s.dispatcher, s.client
+	metrics.RegisterGaugeFunc("leaf_chanrpc_queue_length", "Number of chanrpc calls waiting in ChanCall.", func() float64 {
+		return float64(len(server.ChanCall))
+	}, "module", name)
+	metrics.RegisterGaugeFunc("leaf_timer_queue_length", "Number of fired timers waiting in ChanTimer.", func() float64 {
+		return float64(len(dispatcher.ChanTimer))
+	}, "module", name)
+	metrics.RegisterGaugeFunc("leaf_asyncall_queue_length", "Number of asynchronous call results waiting in ChanAsynRet.", func() float64 {
+		return float64(len(client.ChanAsynRet))
+	}, "module", name)
+}
+
+//回调函数在统计中使用的id，由kind和函数名组成，例如timer:server/game/internal.(*User).autoSaveDB.func1
+//闭包的函数名包含定义它的函数，可以区分不同的定时器和回调，不统计时直接返回kind
+func (s *Skeleton) funcID(kind string, f interface{}) interface{} {
+	if s.stat == nil || f == nil {
+		return kind
+	}
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return kind
+	}
+
+	pc := v.Pointer()
+	if id, ok := s.stat.names[pc]; ok {
+		return id
+	}
+	id := kind
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		id = kind + ":" + fn.Name()
+	}
+	if s.stat.names == nil {
+		s.stat.names = make(map[uintptr]string)
+	}
+	s.stat.names[pc] = id
+	return id
+}
+
+//开始处理一项，返回开始时间
+func (s *Skeleton) begin() time.Time {
+	if s.stat == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+//处理完一项，记录耗时，超过SlowThreshold的记录日志
+func (s *Skeleton) end(id interface{}, start time.Time) {
+	if s.stat == nil {
+		return
+	}
+	d := time.Since(start)
+	slow := s.SlowThreshold > 0 && d >= s.SlowThreshold
+	if slow {
+		log.Error("module %v: slow handler %v took %v", s.stat.name, id, d)
+	}
+
+	s.stat.mutex.Lock()
+	if s.stat.items == nil {
+		s.stat.items = make(map[interface{}]*itemStat)
+	}
+	item, ok := s.stat.items[id]
+	if !ok {
+		item = new(itemStat)
+		s.stat.items[id] = item
+	}
+	item.count++
+	item.total += d
+	if d > item.max {
+		item.max = d
+	}
+	if slow {
+		item.slow++
+	}
+	s.stat.mutex.Unlock()
+
+	if s.stat.handle != nil {
+		h, ok := s.stat.handle[id]
+		if !ok {
+			h = metrics.GetHistogram("leaf_module_handle_seconds", "Time spent handling one item on the module goroutine.", nil,
+				"module", s.stat.name, "id", fmt.Sprint(id))
+			s.stat.handle[id] = h
+		}
+		h.Observe(d.Seconds())
+		s.stat.goPending.Set(float64(s.g.Pending()))
+	}
+}
+
+//输出统计，按总耗时从大到小排列
+func (stat *skeletonStat) dump(reset bool) string {
+	type row struct {
+		id string
+		itemStat
+	}
+
+	stat.mutex.Lock()
+	rows := make([]row, 0, len(stat.items))
+	for id, item := range stat.items {
+		rows = append(rows, row{fmt.Sprint(id), *item})
+	}
+	if reset {
+		stat.items = nil
+	}
+	stat.mutex.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].total > rows[j].total
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v:\r\n", stat.name)
+	fmt.Fprintf(&b, "  %-60v %10v %12v %12v %12v %8v\r\n", "id", "count", "total", "avg", "max", "slow")
+	for _, r := range rows {
+		fmt.Fprintf(&b, "  %-60v %10v %12v %12v %12v %8v\r\n",
+			r.id, r.count, r.total, r.total/time.Duration(r.count), r.max, r.slow)
+	}
+	return b.String()
+}
+
+//控制台命令，输出所有模块的统计
+func commandStats(args []string) string {
+	reset := len(args) > 0 && args[0] == "reset"
+
+	mutexSkeletons.Lock()
+	defer mutexSkeletons.Unlock()
+
+	if len(skeletons) == 0 {
+		return "no module statistics, set Skeleton.SlowThreshold to enable"
+	}
+	var output string
+	for _, s := range skeletons {
+		output += s.stat.dump(reset)
+	}
+	return strings.TrimSuffix(output, "\r\n")
+}
@@ -0,0 +1,136 @@
+package network_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+//并发连接数，每个连接需要两个文件描述符，打开文件数的限制太小时跳过
+const benchmarkConns = 10000
+
+//把收到的消息原样发回，用完后归还读取的缓冲区
+type tcpEchoAgent struct {
+	conn *network.TCPConn
+}
+
+func (a *tcpEchoAgent) Run() {
+	for {
+		msg, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg(msg)
+		a.conn.ReleaseMsg(msg)
+	}
+}
+
+func (a *tcpEchoAgent) OnClose() {}
+
+//10k个连接轮流发送消息，每次给一个连接连续发送burst条，服务器原样发回
+//每个操作是一条消息，allocs/op为每条消息的分配次数(包括服务器和客户端)
+func benchmarkTCPEcho(b *testing.B, msgLen, burst int) {
+	log.SetLevel("error")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	server := new(network.TCPServer)
+	server.Addr = addr
+	server.MaxConnNum = benchmarkConns
+	server.PendingWriteNum = 100
+	server.LenMsgLen = 2
+	server.MaxMsgLen = 4096
+	server.NewAgent = func(conn *network.TCPConn) network.Agent {
+		return &tcpEchoAgent{conn: conn}
+	}
+	server.Start()
+	defer server.Close()
+
+	//一次写入burst条消息
+	frame := make([]byte, 2+msgLen)
+	binary.BigEndian.PutUint16(frame, uint16(msgLen))
+	frames := make([]byte, 0, len(frame)*burst)
+	for i := 0; i < burst; i++ {
+		frames = append(frames, frame...)
+	}
+
+	conns := make([]net.Conn, 0, benchmarkConns)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < benchmarkConns; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Skipf("%v connections: %v", i, err)
+		}
+		conns = append(conns, conn)
+	}
+
+	//每个连接一个goroutine读取回复，window限制发出但还没有收到回复的消息数
+	var wg sync.WaitGroup
+	window := make(chan struct{}, benchmarkConns*burst)
+	for _, conn := range conns {
+		go func(conn net.Conn) {
+			buf := make([]byte, len(frame))
+			for {
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				<-window
+				wg.Done()
+			}
+		}(conn)
+	}
+
+	//每个连接先收发一条消息，服务器接受所有连接后再开始计时
+	wg.Add(len(conns))
+	for _, conn := range conns {
+		window <- struct{}{}
+		if _, err := conn.Write(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(msgLen))
+	b.ResetTimer()
+	wg.Add(b.N)
+	for i, n := 0, 0; n < b.N; i++ {
+		m := burst
+		if b.N-n < m {
+			m = b.N - n
+		}
+		for j := 0; j < m; j++ {
+			window <- struct{}{}
+		}
+		if _, err := conns[i%len(conns)].Write(frames[:len(frame)*m]); err != nil {
+			b.Fatal(err)
+		}
+		n += m
+	}
+	wg.Wait()
+	b.StopTimer()
+}
+
+func BenchmarkTCPEcho(b *testing.B) {
+	for _, msgLen := range []int{64, 1024} {
+		for _, burst := range []int{1, 16} {
+			b.Run(fmt.Sprintf("len=%v/burst=%v", msgLen, burst), func(b *testing.B) {
+				benchmarkTCPEcho(b, msgLen, burst)
+			})
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package network
+
+import (
+	"math/bits"
+	"sync"
+)
+
+//缓冲区池按容量分级，第i级缓冲区的容量为1<<(minBufferShift+i)
+//超过最大容量的缓冲区直接分配，不放回池中
+const (
+	minBufferShift = 6  //最小容量64字节
+	maxBufferShift = 16 //最大容量64K
+)
+
+var (
+	bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool //每级一个池，保存*[]byte
+	holderPool  sync.Pool                                      //空的*[]byte，放回缓冲区时复用，避免每次分配
+)
+
+//能容纳n字节的最小级别
+func bufferClass(n int) int {
+	if n <= 1<<minBufferShift {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minBufferShift
+}
+
+// goroutine safe
+//从池中取得长度为n的缓冲区，内容是未定义的，用完后调用putBuffer放回
+func getBuffer(n int) []byte {
+	class := bufferClass(n)
+	if class >= len(bufferPools) {
+		return make([]byte, n)
+	}
+
+	if v := bufferPools[class].Get(); v != nil {
+		h := v.(*[]byte)
+		b := *h
+		*h = nil
+		holderPool.Put(h)
+		return b[:n]
+	}
+	return make([]byte, n, 1<<(minBufferShift+class))
+}
+
+// goroutine safe
+//把getBuffer取得的缓冲区放回池中，调用后不能再使用b
+//容量不是某一级的缓冲区会被忽略
+func putBuffer(b []byte) {
+	class := bufferClass(cap(b))
+	if class >= len(bufferPools) || cap(b) != 1<<(minBufferShift+class) {
+		return
+	}
+
+	h, _ := holderPool.Get().(*[]byte)
+	if h == nil {
+		h = new([]byte)
+	}
+	*h = b[:0]
+	bufferPools[class].Put(h)
+}
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+)
+
+//连接关闭的原因
+type CloseReason int
+
+const (
+	CloseUnknown        CloseReason = iota //未知原因
+	CloseClientQuit                        //客户端断开连接
+	CloseReadTimeout                       //超过读取超时没有收到数据，客户端可能已经断网
+	CloseKicked                            //服务器主动关闭
+	CloseServerShutdown                    //服务器关闭
+	CloseMsgTooLong                        //收到的消息超过最大长度
+	CloseUnmarshalError                    //消息解码失败
+	CloseRouteError                        //消息分发失败，一般是没有注册路由
+	CloseWriteOverflow                     //发送缓冲区已满，客户端接收太慢
+	CloseRateLimited                       //超过限流并且处理方式为断开连接
+	CloseDeadLink                          //KCP数据段重发太多次没有被确认，对方已经收不到了
+	CloseResumed                           //连接重连到原来的会话，之前为它创建的代理不再使用，不是玩家下线，处理CloseAgent时应该忽略
+)
+
+func (reason CloseReason) String() string {
+	switch reason {
+	case CloseClientQuit:
+		return "client quit"
+	case CloseReadTimeout:
+		return "read timeout"
+	case CloseKicked:
+		return "kicked"
+	case CloseServerShutdown:
+		return "server shutdown"
+	case CloseMsgTooLong:
+		return "message too long"
+	case CloseUnmarshalError:
+		return "unmarshal error"
+	case CloseRouteError:
+		return "route error"
+	case CloseWriteOverflow:
+		return "write overflow"
+	case CloseRateLimited:
+		return "rate limited"
+	case CloseDeadLink:
+		return "dead link"
+	case CloseResumed:
+		return "resumed"
+	default:
+		return "unknown"
+	}
+}
+
+//根据ReadMsg返回的错误判断连接关闭的原因
+func CloseReasonOf(err error) CloseReason {
+	var netErr net.Error
+	var closeErr *websocket.CloseError
+	switch {
+	case err == nil:
+		return CloseUnknown
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+		return CloseClientQuit
+	case errors.As(err, &closeErr): //客户端发送了WebSocket关闭帧
+		return CloseClientQuit
+	case errors.Is(err, errMsgTooLong), errors.Is(err, websocket.ErrReadLimit):
+		return CloseMsgTooLong
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return CloseReadTimeout
+	default:
+		return CloseUnknown
+	}
+}
+
+//读取消息超过最大长度时返回的错误
+var errMsgTooLong = errors.New("message too long")
+
+//连接关闭的原因，只记录第一个原因，可以在多个goroutine中使用
+type closeReason struct {
+	v int32
+}
+
+func (r *closeReason) set(reason CloseReason) {
+	atomic.CompareAndSwapInt32(&r.v, int32(CloseUnknown), int32(reason))
+}
+
+func (r *closeReason) get() CloseReason {
+	return CloseReason(atomic.LoadInt32(&r.v))
+}
@@ -0,0 +1,25 @@
+package network
+
+import (
+	"net"
+)
+
+//连接接口，TCPConn、WSConn和KCPConn均实现了该接口
+type Conn interface {
+	ReadMsg() ([]byte, error)      //读取一条完整的消息
+	WriteMsg(args ...[]byte) error //发送消息
+	LocalAddr() net.Addr           //本地地址
+	RemoteAddr() net.Addr          //远程地址
+	Close()                        //关闭连接(等待发送缓冲区写完)
+	Destroy()                      //销毁连接(丢弃未发送的数据)
+
+	// close reason 关闭原因
+	CloseReason() CloseReason          //连接关闭的原因，还没有关闭时为CloseUnknown
+	SetCloseReason(reason CloseReason) //记录连接关闭的原因，只有第一次记录有效
+
+	// priority 优先级
+	WriteMsgPriority(priority Priority, args ...[]byte) error //按优先级发送消息，只有TCPConn区分优先级
+
+	// buffer pool 缓冲区池
+	ReleaseMsg(b []byte) //ReadMsg返回的消息用完后放回缓冲区池，调用后不能再使用b，不调用也可以
+}
@@ -0,0 +1,244 @@
+package network_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+//模拟丢包的UDP连接，收到和发送的报文都按比例丢弃
+type lossyConn struct {
+	net.PacketConn
+	mutex sync.Mutex
+	rand  *rand.Rand
+	loss  float64
+}
+
+func (c *lossyConn) drop() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.rand.Float64() < c.loss
+}
+
+func (c *lossyConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || !c.drop() {
+			return n, addr, err
+		}
+	}
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.drop() {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+//把收到的消息原样发回
+type echoAgent struct {
+	conn network.Conn
+}
+
+func (a *echoAgent) Run() {
+	for {
+		msg, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg(msg)
+	}
+}
+
+func (a *echoAgent) OnClose() {}
+
+//第i条消息，长度不同，较长的消息会被分成多个段
+func testMsg(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 1+i*97%4000)
+}
+
+//发送所有消息后检查回复的顺序和内容
+type checkAgent struct {
+	conn   network.Conn
+	n      int
+	result chan string
+}
+
+func (a *checkAgent) Run() {
+	for i := 0; i < a.n; i++ {
+		a.conn.WriteMsg(testMsg(i))
+	}
+	for i := 0; i < a.n; i++ {
+		msg, err := a.conn.ReadMsg()
+		if err != nil {
+			a.result <- err.Error()
+			return
+		}
+		if !bytes.Equal(msg, testMsg(i)) {
+			a.result <- fmt.Sprintf("message %v mismatch", i)
+			return
+		}
+	}
+	a.result <- fmt.Sprintf("%v messages echoed in order", a.n)
+}
+
+func (a *checkAgent) OnClose() {}
+
+func ExampleKCPServer() {
+	log.SetLevel("error")
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	config := network.KCPConfig{NoDelay: true, Resend: 2, NoCongestion: true}
+
+	server := new(network.KCPServer)
+	server.Addr = pc.LocalAddr().String()
+	server.MaxConnNum = 10
+	server.PendingWriteNum = 1000
+	server.MaxMsgLen = 4096
+	server.Config = config
+	server.PacketConn = &lossyConn{PacketConn: pc, rand: rand.New(rand.NewSource(1)), loss: 0.2} //丢弃20%的报文
+	server.NewAgent = func(conn *network.KCPConn) network.Agent {
+		return &echoAgent{conn: conn}
+	}
+	server.Start()
+
+	result := make(chan string, 1)
+	client := new(network.KCPClient)
+	client.Addr = server.Addr
+	client.ConnNum = 1
+	client.ConnectInterval = time.Second
+	client.PendingWriteNum = 1000
+	client.MaxMsgLen = 4096
+	client.Config = config
+	client.NewAgent = func(conn *network.KCPConn) network.Agent {
+		return &checkAgent{conn: conn, n: 100, result: result}
+	}
+	client.Start()
+
+	fmt.Println(<-result)
+	client.Close()
+	server.Close()
+
+	// Output:
+	// 100 messages echoed in order
+}
+
+//读取对端收到的所有消息，每条消息只有1个字节
+func readAll(peer net.Conn) []byte {
+	var msgs []byte
+	b := make([]byte, 3)
+	for {
+		if _, err := io.ReadFull(peer, b); err != nil {
+			return msgs
+		}
+		msgs = append(msgs, b[2])
+	}
+}
+
+func ExampleOverflowPolicy() {
+	log.SetLevel("error")
+
+	policies := []struct {
+		policy  network.OverflowPolicy
+		timeout time.Duration
+		spill   int
+	}{
+		{network.OverflowDisconnect, 0, 0},
+		{network.OverflowDropOldest, 0, 0},
+		{network.OverflowSpill, 0, 40},
+		{network.OverflowBlock, 100 * time.Millisecond, 0},
+	}
+	for _, p := range policies {
+		// 发送缓冲区长度为4，对端读取消息0的第1个字节后不再读取，发送goroutine阻塞在消息0上
+		conn, peer := network.NewPipeConn(4, p.policy, p.timeout, p.spill)
+		conn.WriteMsg([]byte{0})
+		peer.Read(make([]byte, 1))
+
+		// 依次发送消息1到20，消息1之后发送一条关键消息99，连接销毁时停止
+		start := time.Now()
+		n := 0
+		for i := 1; i <= 20 && conn.CloseReason() == network.CloseUnknown; i++ {
+			conn.WriteMsg([]byte{byte(i)})
+			if i == 1 {
+				conn.WriteMsgPriority(network.PriorityCritical, []byte{99})
+			}
+			n = i
+		}
+		fmt.Printf("%v: %v messages, %v\n", p.policy, n, conn.CloseReason())
+		if p.policy == network.OverflowBlock {
+			fmt.Println("waited", time.Since(start) >= p.timeout)
+		}
+		if conn.CloseReason() == network.CloseUnknown {
+			conn.Close()
+			peer.Read(make([]byte, 2))
+			fmt.Println("received", readAll(peer))
+		}
+		peer.Close()
+	}
+
+	// Output:
+	// disconnect: 4 messages, write overflow
+	// drop oldest: 20 messages, unknown
+	// received [99 18 19 20]
+	// spill: 13 messages, write overflow
+	// block: 4 messages, write overflow
+	// waited true
+}
+
+func ExampleIPFilter() {
+	check := func(f *network.IPFilter, ip string) {
+		err := f.Check(net.ParseIP(ip))
+		if err == nil {
+			fmt.Println(ip, "ok")
+		} else {
+			fmt.Println(ip, err)
+		}
+	}
+
+	// 黑名单优先于白名单
+	f := new(network.IPFilter)
+	f.SetAllow([]string{"10.0.0.0/8"})
+	f.SetDeny([]string{"10.1.0.0/16", "10.2.3.4"})
+	check(f, "10.0.0.1")
+	check(f, "10.1.2.3")
+	check(f, "10.2.3.4")
+	check(f, "192.168.0.1")
+
+	// 封禁优先于白名单，到期后自动解封
+	f.Ban("10.0.0.1", 50*time.Millisecond)
+	f.Ban("10.0.0.2", 0)
+	check(f, "10.0.0.1")
+	fmt.Println(len(f.Bans()))
+	time.Sleep(100 * time.Millisecond)
+	check(f, "10.0.0.1")
+	fmt.Println(len(f.Bans()))
+
+	// 永久封禁需要手动解封
+	check(f, "10.0.0.2")
+	fmt.Println(f.Unban("10.0.0.2"), f.Unban("10.0.0.2"))
+	check(f, "10.0.0.2")
+
+	// Output:
+	// 10.0.0.1 ok
+	// 10.1.2.3 ip denied
+	// 10.2.3.4 ip denied
+	// 192.168.0.1 ip not allowed
+	// 10.0.0.1 ip banned
+	// 2
+	// 10.0.0.1 ok
+	// 1
+	// 10.0.0.2 ip banned
+	// true false
+	// 10.0.0.2 ok
+}
@@ -0,0 +1,16 @@
+package network
+
+import (
+	"net"
+	"time"
+)
+
+//示例使用的TCP连接，底层为net.Pipe，对端不读取时发送goroutine阻塞，发送缓冲区的消息不会被取走
+func NewPipeConn(pendingWriteNum int, policy OverflowPolicy, blockTimeout time.Duration, spillBytes int) (*TCPConn, net.Conn) {
+	conn, peer := net.Pipe()
+	tcpConn := newTCPConn(conn, pendingWriteNum, NewMsgParser())
+	tcpConn.overflow = policy
+	tcpConn.blockTimeout = blockTimeout
+	tcpConn.spillBytes = spillBytes
+	return tcpConn, peer
+}
@@ -0,0 +1,225 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//默认的IP过滤器，控制台的ip命令管理的就是这个过滤器
+//需要过滤的服务器把它赋给TCPServer、WSServer或KCPServer的Filter
+var DefaultIPFilter = new(IPFilter)
+
+//IP过滤器，在接受连接时检查对方的IP，零值可以直接使用
+//依次检查封禁列表、黑名单和白名单，白名单为空时不检查白名单
+type IPFilter struct {
+	mutex sync.Mutex           //互斥锁
+	allow []*net.IPNet         //白名单
+	deny  []*net.IPNet         //黑名单
+	bans  map[string]time.Time //封禁的IP->解封时间，零值为永久封禁
+}
+
+//封禁信息
+type IPBan struct {
+	IP     string    //IP
+	Expire time.Time //解封时间，零值为永久封禁
+}
+
+var (
+	errIPBanned     = errors.New("ip banned")
+	errIPDenied     = errors.New("ip denied")
+	errIPNotAllowed = errors.New("ip not allowed")
+)
+
+//解析CIDR，单个IP视为只包含这个IP的网段
+func parseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.New("invalid ip: " + s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(s)
+	return ipNet, err
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		ipNet, err := parseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func formatCIDRs(nets []*net.IPNet) []string {
+	cidrs := make([]string, len(nets))
+	for i, ipNet := range nets {
+		cidrs[i] = ipNet.String()
+	}
+	return cidrs
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// goroutine safe
+//设置白名单，元素为CIDR或者IP，为空时允许所有不在黑名单中的IP
+func (f *IPFilter) SetAllow(cidrs []string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.allow = nets
+	return nil
+}
+
+// goroutine safe
+//设置黑名单，元素为CIDR或者IP
+func (f *IPFilter) SetDeny(cidrs []string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.deny = nets
+	return nil
+}
+
+// goroutine safe
+//白名单
+func (f *IPFilter) Allow() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return formatCIDRs(f.allow)
+}
+
+// goroutine safe
+//黑名单
+func (f *IPFilter) Deny() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return formatCIDRs(f.deny)
+}
+
+// goroutine safe
+//封禁IP，d为封禁时间，为0时永久封禁，再次封禁会覆盖原来的解封时间
+//只影响之后的连接，已经建立的连接需要使用者自己关闭
+func (f *IPFilter) Ban(ip string, d time.Duration) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return errors.New("invalid ip: " + ip)
+	}
+
+	var expire time.Time
+	if d > 0 {
+		expire = time.Now().Add(d)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.bans == nil {
+		f.bans = make(map[string]time.Time)
+	}
+	f.bans[parsed.String()] = expire
+	return nil
+}
+
+// goroutine safe
+//解封IP，IP没有被封禁时返回false
+func (f *IPFilter) Unban(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := parsed.String()
+	if _, ok := f.bans[key]; !ok {
+		return false
+	}
+	delete(f.bans, key)
+	return true
+}
+
+// goroutine safe
+//所有封禁中的IP，按IP排序
+func (f *IPFilter) Bans() []IPBan {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	now := time.Now()
+	bans := make([]IPBan, 0, len(f.bans))
+	for ip, expire := range f.bans {
+		if !expire.IsZero() && !now.Before(expire) { //已经解封
+			delete(f.bans, ip)
+			continue
+		}
+		bans = append(bans, IPBan{IP: ip, Expire: expire})
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].IP < bans[j].IP
+	})
+	return bans
+}
+
+// goroutine safe
+//检查IP是否允许连接，不允许时返回原因
+func (f *IPFilter) Check(ip net.IP) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if expire, ok := f.bans[ip.String()]; ok {
+		if expire.IsZero() || time.Now().Before(expire) {
+			return errIPBanned
+		}
+		delete(f.bans, ip.String()) //已经解封
+	}
+	if containsIP(f.deny, ip) {
+		return errIPDenied
+	}
+	if len(f.allow) > 0 && !containsIP(f.allow, ip) {
+		return errIPNotAllowed
+	}
+	return nil
+}
+
+//连接的对方IP
+func remoteIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return hostIP(conn.RemoteAddr().String())
+}
+
+//从host:port形式的地址中取得IP，例如http.Request.RemoteAddr
+func hostIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
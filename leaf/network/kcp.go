@@ -0,0 +1,661 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+//KCP协议的实现，只负责可靠传输的状态，不做任何IO，由KCPConn驱动
+//每个报文由若干个段组成，每个段的格式如下(小端)
+//------------------------------------------------------------------------------------
+//| conv(4) | cmd(1) | frg(1) | wnd(2) | ts(4) | sn(4) | una(4) | len(4) | data(len) |
+//------------------------------------------------------------------------------------
+//conv为会话编号，frg为消息剩余的分片数，wnd为接收窗口剩余大小，ts为发送时间，sn为序号，una为对方期望收到的下一个序号
+
+const (
+	kcpCmdPush = 81 //数据
+	kcpCmdAck  = 82 //确认
+	kcpCmdWask = 83 //询问对方的窗口大小
+	kcpCmdWins = 84 //告诉对方自己的窗口大小
+
+	kcpAskSend = 1 //需要发送kcpCmdWask
+	kcpAskTell = 2 //需要发送kcpCmdWins
+
+	kcpOverhead   = 24     //段头长度
+	kcpRTONoDelay = 30     //nodelay时的最小RTO，单位毫秒
+	kcpRTOMin     = 100    //最小RTO
+	kcpRTODef     = 200    //初始RTO
+	kcpRTOMax     = 60000  //最大RTO
+	kcpThreshInit = 2      //初始慢启动阈值
+	kcpThreshMin  = 2      //最小慢启动阈值
+	kcpProbeInit  = 7000   //对方窗口为0时，第一次询问的等待时间
+	kcpProbeLimit = 120000 //询问的最大等待时间
+	kcpDeadLink   = 20     //一个段重发这么多次后认为连接断开
+	kcpFastLimit  = 5      //一个段最多快速重传的次数
+)
+
+var errKCPInput = errors.New("invalid kcp packet")
+
+//比较序号或者时间，处理回绕
+func timediff(later, earlier uint32) int32 {
+	return int32(later - earlier)
+}
+
+//段
+type kcpSegment struct {
+	conv     uint32
+	cmd      uint8
+	frg      uint8
+	wnd      uint16
+	ts       uint32
+	sn       uint32
+	una      uint32
+	resendts uint32 //下次重发的时间
+	rto      uint32 //重发超时
+	fastack  uint32 //被跳过确认的次数，用于快速重传
+	xmit     uint32 //发送的次数
+	data     []byte
+}
+
+//编码段头并追加数据
+func (seg *kcpSegment) encode(buf []byte) []byte {
+	var h [kcpOverhead]byte
+	binary.LittleEndian.PutUint32(h[0:], seg.conv)
+	h[4] = seg.cmd
+	h[5] = seg.frg
+	binary.LittleEndian.PutUint16(h[6:], seg.wnd)
+	binary.LittleEndian.PutUint32(h[8:], seg.ts)
+	binary.LittleEndian.PutUint32(h[12:], seg.sn)
+	binary.LittleEndian.PutUint32(h[16:], seg.una)
+	binary.LittleEndian.PutUint32(h[20:], uint32(len(seg.data)))
+	buf = append(buf, h[:]...)
+	return append(buf, seg.data...)
+}
+
+//待发送的确认
+type kcpAck struct {
+	sn uint32
+	ts uint32
+}
+
+//KCP控制块，不是goroutine safe，由KCPConn加锁使用
+type kcp struct {
+	conv    uint32
+	mtu     int
+	mss     int //单个段最大数据长度
+	dead    bool
+	current uint32 //当前时间，单位毫秒
+
+	sndUna uint32 //第一个没有被确认的序号
+	sndNxt uint32 //下一个发送的序号
+	rcvNxt uint32 //期望收到的下一个序号
+
+	ssthresh uint32
+	cwnd     uint32
+	incr     uint32
+	sndWnd   uint32
+	rcvWnd   uint32
+	rmtWnd   uint32
+
+	rxRttval int32
+	rxSrtt   int32
+	rxRto    uint32
+	rxMinrto uint32
+
+	probe     uint32
+	tsProbe   uint32
+	probeWait uint32
+
+	interval   uint32
+	tsFlush    uint32
+	updated    bool
+	nodelay    bool
+	fastresend int
+	nocwnd     bool
+
+	sndQueue []*kcpSegment //还没有进入发送窗口的段
+	sndBuf   []*kcpSegment //已经发送等待确认的段
+	rcvQueue []*kcpSegment //按顺序收到的段，等待读取
+	rcvBuf   []*kcpSegment //乱序收到的段
+	acklist  []kcpAck
+
+	buffer []byte            //发送缓冲，攒够一个MTU再输出
+	output func(data []byte) //输出一个报文，data在调用返回后会被复用
+}
+
+func newKCP(conv uint32, output func(data []byte)) *kcp {
+	k := new(kcp)
+	k.conv = conv
+	k.sndWnd = 32
+	k.rcvWnd = 128
+	k.rmtWnd = 128
+	k.rxRto = kcpRTODef
+	k.rxMinrto = kcpRTOMin
+	k.interval = 100
+	k.ssthresh = kcpThreshInit
+	k.output = output
+	k.setMTU(1400)
+	return k
+}
+
+func (k *kcp) setMTU(mtu int) {
+	if mtu <= kcpOverhead {
+		return
+	}
+	k.mtu = mtu
+	k.mss = mtu - kcpOverhead
+	k.buffer = make([]byte, 0, mtu)
+}
+
+//设置发送和接收窗口
+func (k *kcp) setWndSize(sndWnd, rcvWnd int) {
+	if sndWnd > 0 {
+		k.sndWnd = uint32(sndWnd)
+	}
+	if rcvWnd > 0 {
+		k.rcvWnd = uint32(rcvWnd)
+	}
+}
+
+//nodelay: 是否使用更小的最小RTO和更缓和的RTO增长，interval: 刷新间隔(毫秒)
+//resend: 被跳过多少次确认后快速重传，为0不快速重传，nc: 是否关闭拥塞控制
+func (k *kcp) setNoDelay(nodelay bool, interval int, resend int, nc bool) {
+	k.nodelay = nodelay
+	if nodelay {
+		k.rxMinrto = kcpRTONoDelay
+	} else {
+		k.rxMinrto = kcpRTOMin
+	}
+	if interval < 10 {
+		interval = 10
+	} else if interval > 5000 {
+		interval = 5000
+	}
+	k.interval = uint32(interval)
+	k.fastresend = resend
+	k.nocwnd = nc
+}
+
+//单个消息最多的分片数
+func (k *kcp) maxFragments() int {
+	n := int(k.rcvWnd) - 1
+	if n > 255 {
+		n = 255
+	}
+	return n
+}
+
+//发送一个消息，太大时分片
+func (k *kcp) send(data []byte) error {
+	count := (len(data) + k.mss - 1) / k.mss
+	if count == 0 {
+		count = 1
+	}
+	if count > k.maxFragments()+1 {
+		return errors.New("message too long")
+	}
+
+	for i := 0; i < count; i++ {
+		size := len(data)
+		if size > k.mss {
+			size = k.mss
+		}
+		seg := new(kcpSegment)
+		seg.data = append([]byte(nil), data[:size]...)
+		seg.frg = uint8(count - i - 1)
+		k.sndQueue = append(k.sndQueue, seg)
+		data = data[size:]
+	}
+	return nil
+}
+
+//下一个完整消息的长度，没有完整的消息时返回-1
+func (k *kcp) peekSize() int {
+	if len(k.rcvQueue) == 0 {
+		return -1
+	}
+	seg := k.rcvQueue[0]
+	if seg.frg == 0 {
+		return len(seg.data)
+	}
+	if len(k.rcvQueue) < int(seg.frg)+1 {
+		return -1
+	}
+
+	length := 0
+	for _, seg := range k.rcvQueue {
+		length += len(seg.data)
+		if seg.frg == 0 {
+			break
+		}
+	}
+	return length
+}
+
+//读取一个完整的消息，没有时返回nil
+func (k *kcp) recv() []byte {
+	size := k.peekSize()
+	if size < 0 {
+		return nil
+	}
+	recover := len(k.rcvQueue) >= int(k.rcvWnd)
+
+	data := make([]byte, 0, size)
+	n := 0
+	for _, seg := range k.rcvQueue {
+		data = append(data, seg.data...)
+		n++
+		if seg.frg == 0 {
+			break
+		}
+	}
+	k.rcvQueue = removeSegments(k.rcvQueue, n)
+	k.moveRcvBuf()
+
+	if len(k.rcvQueue) < int(k.rcvWnd) && recover { //窗口从满变为不满，马上告诉对方
+		k.probe |= kcpAskTell
+	}
+	return data
+}
+
+//去掉前n个段
+func removeSegments(segs []*kcpSegment, n int) []*kcpSegment {
+	for i := 0; i < n; i++ {
+		segs[i] = nil
+	}
+	return segs[n:]
+}
+
+//把rcvBuf中连续的段移到rcvQueue
+func (k *kcp) moveRcvBuf() {
+	n := 0
+	for _, seg := range k.rcvBuf {
+		if seg.sn != k.rcvNxt || len(k.rcvQueue) >= int(k.rcvWnd) {
+			break
+		}
+		k.rcvQueue = append(k.rcvQueue, seg)
+		k.rcvNxt++
+		n++
+	}
+	k.rcvBuf = removeSegments(k.rcvBuf, n)
+}
+
+//等待发送和等待确认的段数
+func (k *kcp) waitSnd() int {
+	return len(k.sndBuf) + len(k.sndQueue)
+}
+
+//根据RTT更新RTO
+func (k *kcp) updateAck(rtt int32) {
+	if k.rxSrtt == 0 {
+		k.rxSrtt = rtt
+		k.rxRttval = rtt / 2
+	} else {
+		delta := rtt - k.rxSrtt
+		if delta < 0 {
+			delta = -delta
+		}
+		k.rxRttval = (3*k.rxRttval + delta) / 4
+		k.rxSrtt = (7*k.rxSrtt + rtt) / 8
+		if k.rxSrtt < 1 {
+			k.rxSrtt = 1
+		}
+	}
+	rto := uint32(k.rxSrtt) + max(k.interval, uint32(4*k.rxRttval))
+	k.rxRto = min(max(k.rxMinrto, rto), kcpRTOMax)
+}
+
+func (k *kcp) shrinkBuf() {
+	if len(k.sndBuf) > 0 {
+		k.sndUna = k.sndBuf[0].sn
+	} else {
+		k.sndUna = k.sndNxt
+	}
+}
+
+//对方确认收到了sn
+func (k *kcp) parseAck(sn uint32) {
+	if timediff(sn, k.sndUna) < 0 || timediff(sn, k.sndNxt) >= 0 {
+		return
+	}
+	for i, seg := range k.sndBuf {
+		if sn == seg.sn {
+			copy(k.sndBuf[i:], k.sndBuf[i+1:])
+			k.sndBuf[len(k.sndBuf)-1] = nil
+			k.sndBuf = k.sndBuf[:len(k.sndBuf)-1]
+			break
+		}
+		if timediff(sn, seg.sn) < 0 {
+			break
+		}
+	}
+}
+
+//对方确认收到了una之前的所有段
+func (k *kcp) parseUna(una uint32) {
+	n := 0
+	for _, seg := range k.sndBuf {
+		if timediff(una, seg.sn) > 0 {
+			n++
+		} else {
+			break
+		}
+	}
+	k.sndBuf = removeSegments(k.sndBuf, n)
+}
+
+//sn之前没有被确认的段被跳过了一次
+func (k *kcp) parseFastack(sn uint32) {
+	if timediff(sn, k.sndUna) < 0 || timediff(sn, k.sndNxt) >= 0 {
+		return
+	}
+	for _, seg := range k.sndBuf {
+		if timediff(sn, seg.sn) < 0 {
+			break
+		} else if sn != seg.sn {
+			seg.fastack++
+		}
+	}
+}
+
+//收到数据段，放入rcvBuf并移动连续的段到rcvQueue
+func (k *kcp) parseData(newseg *kcpSegment) {
+	sn := newseg.sn
+	if timediff(sn, k.rcvNxt+k.rcvWnd) >= 0 || timediff(sn, k.rcvNxt) < 0 {
+		return
+	}
+
+	i := len(k.rcvBuf) - 1
+	repeat := false
+	for ; i >= 0; i-- {
+		seg := k.rcvBuf[i]
+		if seg.sn == sn {
+			repeat = true
+			break
+		}
+		if timediff(sn, seg.sn) > 0 {
+			break
+		}
+	}
+	if !repeat { //插入到i之后
+		k.rcvBuf = append(k.rcvBuf, nil)
+		copy(k.rcvBuf[i+2:], k.rcvBuf[i+1:])
+		k.rcvBuf[i+1] = newseg
+	}
+
+	k.moveRcvBuf()
+}
+
+//处理收到的报文
+func (k *kcp) input(data []byte) error {
+	prevUna := k.sndUna
+	var maxack uint32
+	flag := false
+
+	if len(data) < kcpOverhead {
+		return errKCPInput
+	}
+
+	for len(data) >= kcpOverhead {
+		conv := binary.LittleEndian.Uint32(data)
+		cmd := data[4]
+		frg := data[5]
+		wnd := binary.LittleEndian.Uint16(data[6:])
+		ts := binary.LittleEndian.Uint32(data[8:])
+		sn := binary.LittleEndian.Uint32(data[12:])
+		una := binary.LittleEndian.Uint32(data[16:])
+		length := binary.LittleEndian.Uint32(data[20:])
+		data = data[kcpOverhead:]
+
+		if conv != k.conv || uint32(len(data)) < length {
+			return errKCPInput
+		}
+		if cmd != kcpCmdPush && cmd != kcpCmdAck && cmd != kcpCmdWask && cmd != kcpCmdWins {
+			return errKCPInput
+		}
+
+		k.rmtWnd = uint32(wnd)
+		k.parseUna(una)
+		k.shrinkBuf()
+
+		switch cmd {
+		case kcpCmdAck:
+			if rtt := timediff(k.current, ts); rtt >= 0 {
+				k.updateAck(rtt)
+			}
+			k.parseAck(sn)
+			k.shrinkBuf()
+			if !flag {
+				flag = true
+				maxack = sn
+			} else if timediff(sn, maxack) > 0 {
+				maxack = sn
+			}
+		case kcpCmdPush:
+			if timediff(sn, k.rcvNxt+k.rcvWnd) < 0 {
+				k.acklist = append(k.acklist, kcpAck{sn, ts}) //重复的段也要确认，对方可能没收到确认
+				if timediff(sn, k.rcvNxt) >= 0 {
+					seg := new(kcpSegment)
+					seg.conv = conv
+					seg.cmd = cmd
+					seg.frg = frg
+					seg.wnd = wnd
+					seg.ts = ts
+					seg.sn = sn
+					seg.una = una
+					seg.data = append([]byte(nil), data[:length]...)
+					k.parseData(seg)
+				}
+			}
+		case kcpCmdWask:
+			k.probe |= kcpAskTell
+		case kcpCmdWins:
+			//对方的窗口已经在上面更新
+		}
+
+		data = data[length:]
+	}
+
+	if flag {
+		k.parseFastack(maxack)
+	}
+
+	//拥塞窗口增长
+	if timediff(k.sndUna, prevUna) > 0 && k.cwnd < k.rmtWnd {
+		mss := uint32(k.mss)
+		if k.cwnd < k.ssthresh { //慢启动
+			k.cwnd++
+			k.incr += mss
+		} else { //拥塞避免
+			if k.incr < mss {
+				k.incr = mss
+			}
+			k.incr += (mss*mss)/k.incr + mss/16
+			if (k.cwnd+1)*mss <= k.incr {
+				k.cwnd++
+			}
+		}
+		if k.cwnd > k.rmtWnd {
+			k.cwnd = k.rmtWnd
+			k.incr = k.rmtWnd * mss
+		}
+	}
+
+	return nil
+}
+
+//接收窗口剩余大小
+func (k *kcp) wndUnused() uint16 {
+	if len(k.rcvQueue) < int(k.rcvWnd) {
+		return uint16(int(k.rcvWnd) - len(k.rcvQueue))
+	}
+	return 0
+}
+
+//缓冲中放不下size字节时先输出
+func (k *kcp) reserve(size int) {
+	if len(k.buffer)+size > k.mtu {
+		k.output(k.buffer)
+		k.buffer = k.buffer[:0]
+	}
+}
+
+//发送确认、窗口探测和数据
+func (k *kcp) flush() {
+	current := k.current
+	seg := kcpSegment{conv: k.conv, cmd: kcpCmdAck, wnd: k.wndUnused(), una: k.rcvNxt}
+
+	//确认
+	for _, ack := range k.acklist {
+		k.reserve(kcpOverhead)
+		seg.sn, seg.ts = ack.sn, ack.ts
+		k.buffer = seg.encode(k.buffer)
+	}
+	k.acklist = k.acklist[:0]
+
+	//对方的窗口为0时，定时询问
+	if k.rmtWnd == 0 {
+		if k.probeWait == 0 {
+			k.probeWait = kcpProbeInit
+			k.tsProbe = current + k.probeWait
+		} else if timediff(current, k.tsProbe) >= 0 {
+			if k.probeWait < kcpProbeInit {
+				k.probeWait = kcpProbeInit
+			}
+			k.probeWait += k.probeWait / 2
+			if k.probeWait > kcpProbeLimit {
+				k.probeWait = kcpProbeLimit
+			}
+			k.tsProbe = current + k.probeWait
+			k.probe |= kcpAskSend
+		}
+	} else {
+		k.tsProbe = 0
+		k.probeWait = 0
+	}
+
+	if k.probe&kcpAskSend != 0 {
+		seg.cmd = kcpCmdWask
+		k.reserve(kcpOverhead)
+		k.buffer = seg.encode(k.buffer)
+	}
+	if k.probe&kcpAskTell != 0 {
+		seg.cmd = kcpCmdWins
+		k.reserve(kcpOverhead)
+		k.buffer = seg.encode(k.buffer)
+	}
+	k.probe = 0
+
+	//发送窗口
+	cwnd := min(k.sndWnd, k.rmtWnd)
+	if !k.nocwnd {
+		cwnd = min(k.cwnd, cwnd)
+	}
+
+	//把sndQueue中的段移到sndBuf
+	n := 0
+	for _, newseg := range k.sndQueue {
+		if timediff(k.sndNxt, k.sndUna+cwnd) >= 0 {
+			break
+		}
+		newseg.conv = k.conv
+		newseg.cmd = kcpCmdPush
+		newseg.sn = k.sndNxt
+		k.sndNxt++
+		k.sndBuf = append(k.sndBuf, newseg)
+		n++
+	}
+	k.sndQueue = removeSegments(k.sndQueue, n)
+
+	resent := uint32(0xffffffff)
+	if k.fastresend > 0 {
+		resent = uint32(k.fastresend)
+	}
+	rtomin := k.rxRto >> 3
+	if k.nodelay {
+		rtomin = 0
+	}
+
+	lost := false
+	change := false
+	for _, segment := range k.sndBuf {
+		needsend := false
+		if segment.xmit == 0 { //第一次发送
+			needsend = true
+			segment.rto = k.rxRto
+			segment.resendts = current + segment.rto + rtomin
+		} else if timediff(current, segment.resendts) >= 0 { //超时重传
+			needsend = true
+			if !k.nodelay {
+				segment.rto += max(segment.rto, k.rxRto)
+			} else {
+				segment.rto += k.rxRto / 2
+			}
+			segment.resendts = current + segment.rto
+			lost = true
+		} else if segment.fastack >= resent && segment.xmit <= kcpFastLimit { //快速重传
+			needsend = true
+			segment.fastack = 0
+			segment.resendts = current + segment.rto
+			change = true
+		}
+
+		if needsend {
+			segment.xmit++
+			segment.ts = current
+			segment.wnd = seg.wnd
+			segment.una = k.rcvNxt
+
+			k.reserve(kcpOverhead + len(segment.data))
+			k.buffer = segment.encode(k.buffer)
+
+			if segment.xmit >= kcpDeadLink {
+				k.dead = true
+			}
+		}
+	}
+
+	if len(k.buffer) > 0 {
+		k.output(k.buffer)
+		k.buffer = k.buffer[:0]
+	}
+
+	//更新拥塞窗口
+	if change {
+		inflight := k.sndNxt - k.sndUna
+		k.ssthresh = max(inflight/2, kcpThreshMin)
+		k.cwnd = k.ssthresh + resent
+		k.incr = k.cwnd * uint32(k.mss)
+	}
+	if lost {
+		k.ssthresh = max(cwnd/2, kcpThreshMin)
+		k.cwnd = 1
+		k.incr = uint32(k.mss)
+	}
+	if k.cwnd < 1 {
+		k.cwnd = 1
+		k.incr = uint32(k.mss)
+	}
+}
+
+//更新时间，到了刷新时间时刷新，current单位为毫秒
+func (k *kcp) update(current uint32) {
+	k.current = current
+	if !k.updated {
+		k.updated = true
+		k.tsFlush = current
+	}
+
+	slap := timediff(current, k.tsFlush)
+	if slap >= 10000 || slap < -10000 {
+		k.tsFlush = current
+		slap = 0
+	}
+	if slap >= 0 {
+		k.tsFlush += k.interval
+		if timediff(current, k.tsFlush) >= 0 {
+			k.tsFlush = current + k.interval
+		}
+		k.flush()
+	}
+}
@@ -0,0 +1,167 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/name5566/leaf/log"
+	"net"
+	"sync"
+	"time"
+)
+
+//KCP客户端，每个连接使用一个单独的UDP端口
+//UDP不需要握手，连接在第一条消息被服务器收到时建立，收不到确认或者超时后断开
+type KCPClient struct {
+	sync.Mutex
+	Addr            string
+	ConnNum         int
+	ConnectInterval time.Duration
+	PendingWriteNum int //等待发送和确认的最大段数，超过时断开连接
+	MaxMsgLen       uint32
+	AutoReconnect   bool
+	NewAgent        func(*KCPConn) Agent
+	Config          KCPConfig //KCP配置
+	conns           map[*KCPConn]struct{}
+	wg              sync.WaitGroup
+	closeFlag       bool
+}
+
+func (client *KCPClient) Start() {
+	client.init()
+
+	for i := 0; i < client.ConnNum; i++ {
+		client.wg.Add(1)
+		go client.connect()
+	}
+}
+
+func (client *KCPClient) init() {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.ConnNum <= 0 {
+		client.ConnNum = 1
+		log.Release("invalid ConnNum, reset to %v", client.ConnNum)
+	}
+	if client.ConnectInterval <= 0 {
+		client.ConnectInterval = 3 * time.Second
+		log.Release("invalid ConnectInterval, reset to %v", client.ConnectInterval)
+	}
+	if client.PendingWriteNum <= 0 {
+		client.PendingWriteNum = 100
+		log.Release("invalid PendingWriteNum, reset to %v", client.PendingWriteNum)
+	}
+	if client.MaxMsgLen == 0 {
+		client.MaxMsgLen = 4096
+		log.Release("invalid MaxMsgLen, reset to %v", client.MaxMsgLen)
+	}
+	if client.NewAgent == nil {
+		log.Fatal("NewAgent must not be nil")
+	}
+	if client.conns != nil {
+		log.Fatal("client is running")
+	}
+
+	client.conns = make(map[*KCPConn]struct{})
+	client.closeFlag = false
+}
+
+func (client *KCPClient) dial() net.Conn {
+	for {
+		conn, err := net.Dial("udp", client.Addr)
+		if err == nil || client.closeFlag {
+			return conn
+		}
+
+		log.Release("connect to %v error: %v", client.Addr, err)
+		time.Sleep(client.ConnectInterval)
+		continue
+	}
+}
+
+//随机的会话编号
+func newConv() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.LittleEndian.Uint32(b[:])
+}
+
+func (client *KCPClient) connect() {
+	defer client.wg.Done()
+
+reconnect:
+	conn := client.dial()
+	if conn == nil {
+		return
+	}
+
+	client.Lock()
+	if client.closeFlag {
+		conn.Close()
+		client.Unlock()
+		return
+	}
+	kcpConn := newKCPConn(newConv(), &client.Config, conn.LocalAddr(), conn.RemoteAddr(),
+		client.PendingWriteNum, client.MaxMsgLen, func(b []byte) {
+			conn.Write(b)
+		}, func() {
+			conn.Close()
+		})
+	client.conns[kcpConn] = struct{}{}
+	client.Unlock()
+
+	client.wg.Add(1)
+	go client.read(conn, kcpConn)
+	kcpConn.askWindow() //服务器收到后创建会话，服务器可以先发送消息
+
+	agent := client.NewAgent(kcpConn)
+	agent.Run()
+
+	// cleanup
+	//等已发送的消息被确认，期间Close可以销毁连接
+	kcpConn.Close()
+	agent.OnClose()
+	<-kcpConn.done
+	client.Lock()
+	if client.conns != nil {
+		delete(client.conns, kcpConn)
+	}
+	client.Unlock()
+
+	client.Lock()
+	closeFlag := client.closeFlag
+	client.Unlock()
+	if client.AutoReconnect && !closeFlag {
+		time.Sleep(client.ConnectInterval)
+		goto reconnect
+	}
+}
+
+//接收UDP报文，直到连接销毁时关闭底层连接
+func (client *KCPClient) read(conn net.Conn, kcpConn *KCPConn) {
+	defer client.wg.Done()
+
+	buf := make([]byte, 65536)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil { //对方端口不可达时也会出错
+			kcpConn.Destroy()
+			return
+		}
+		kcpConn.input(buf[:n])
+	}
+}
+
+func (client *KCPClient) Close() {
+	client.Lock()
+	client.closeFlag = true
+	if client.conns != nil {
+		for kcpConn := range client.conns {
+			kcpConn.Destroy()
+		}
+		client.conns = nil
+	}
+	client.Unlock()
+
+	client.wg.Wait()
+}
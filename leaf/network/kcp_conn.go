@@ -0,0 +1,324 @@
+package network
+
+import (
+	"errors"
+	"github.com/name5566/leaf/log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+//重发太多次后对方还没有确认
+var errDeadLink = errors.New("dead link")
+
+//KCP配置，KCPServer和KCPClient共用，两端的MTU需要相同
+type KCPConfig struct {
+	MTU          int           //最大报文长度，为0时使用1400
+	SendWnd      int           //发送窗口，单位为段，为0时使用32
+	RecvWnd      int           //接收窗口，单位为段，为0时使用128，单个消息最多分成RecvWnd-1个段
+	NoDelay      bool          //使用更小的最小RTO(30ms)和更缓和的RTO退避，丢包时延迟更低
+	Interval     time.Duration //刷新间隔，确认和超时重传在刷新时发送，为0时使用10ms
+	Resend       int           //被跳过多少次确认后快速重传，为0不快速重传
+	NoCongestion bool          //关闭拥塞控制，只受发送窗口和对方接收窗口限制
+	Timeout      time.Duration //多久没有收到对方的报文就断开连接，为0时使用30s，空闲时会定时发送心跳
+}
+
+func (c *KCPConfig) interval() time.Duration {
+	if c.Interval > 0 {
+		return c.Interval
+	}
+	return 10 * time.Millisecond
+}
+
+func (c *KCPConfig) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return 30 * time.Second
+}
+
+func (c *KCPConfig) recvWnd() uint32 {
+	if c.RecvWnd > 0 {
+		return uint32(c.RecvWnd)
+	}
+	return 128
+}
+
+func (c *KCPConfig) mtu() int {
+	if c.MTU > kcpOverhead {
+		return c.MTU
+	}
+	return 1400
+}
+
+//按配置创建KCP控制块
+func (c *KCPConfig) newKCP(conv uint32, output func(data []byte)) *kcp {
+	k := newKCP(conv, output)
+	k.setMTU(c.mtu())
+	k.setWndSize(c.SendWnd, c.RecvWnd)
+	k.setNoDelay(c.NoDelay, int(c.interval()/time.Millisecond), c.Resend, c.NoCongestion)
+	return k
+}
+
+//KCP连接，在UDP上提供可靠有序的消息传输，一个KCP报文段序列对应一条leaf消息，不需要len字段
+//UDP没有断开的通知，对方在KCPConfig.Timeout之后才会发现连接断开
+type KCPConn struct {
+	sync.Mutex                    //匿名字段
+	cond            *sync.Cond    //等待消息
+	kcp             *kcp          //KCP控制块
+	localAddr       net.Addr      //本地地址
+	remoteAddr      net.Addr      //远程地址
+	write           func([]byte)  //发送一个UDP报文
+	release         func()        //销毁后释放底层资源，可以为nil
+	pendingWriteNum int           //等待发送和确认的最大段数
+	maxMsgLen       uint32        //最大消息长度
+	timeout         time.Duration //接收超时
+	start           time.Time     //创建时间，KCP的时钟从0开始
+	lastRecv        time.Time     //上次收到报文的时间
+	lastSend        time.Time     //上次发送报文的时间
+	closeFlag       bool          //关闭标志，不再发送和读取新的消息
+	destroyed       bool          //销毁标志
+	err             error         //销毁的原因，由ReadMsg返回，为nil时返回连接已关闭
+	done            chan struct{} //销毁时关闭
+
+	// close reason 关闭原因
+	reason closeReason //连接关闭的原因
+}
+
+//新建KCP连接，write在持有连接的锁时调用
+func newKCPConn(conv uint32, config *KCPConfig, localAddr, remoteAddr net.Addr,
+	pendingWriteNum int, maxMsgLen uint32, write func([]byte), release func()) *KCPConn {
+	kcpConn := new(KCPConn)
+	kcpConn.cond = sync.NewCond(&kcpConn.Mutex)
+	kcpConn.localAddr = localAddr
+	kcpConn.remoteAddr = remoteAddr
+	kcpConn.write = write
+	kcpConn.release = release
+	kcpConn.pendingWriteNum = pendingWriteNum
+	kcpConn.maxMsgLen = maxMsgLen
+	kcpConn.timeout = config.timeout()
+	kcpConn.start = time.Now()
+	kcpConn.lastRecv = kcpConn.start
+	kcpConn.lastSend = kcpConn.start
+	kcpConn.done = make(chan struct{})
+	kcpConn.kcp = config.newKCP(conv, func(data []byte) {
+		kcpConn.write(data)
+		kcpConn.lastSend = time.Now()
+	})
+
+	go kcpConn.run(config.interval()) //在一个新的goroutine中定时刷新
+
+	return kcpConn
+}
+
+//KCP时钟，单位毫秒
+func (kcpConn *KCPConn) clock() uint32 {
+	return uint32(time.Since(kcpConn.start) / time.Millisecond)
+}
+
+//定时刷新，直到连接销毁
+func (kcpConn *KCPConn) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-kcpConn.done:
+			return
+		case <-ticker.C:
+		}
+
+		kcpConn.Lock()
+		kcpConn.update()
+		kcpConn.Unlock()
+	}
+}
+
+//检查连接状态并刷新，调用前需要加锁
+func (kcpConn *KCPConn) update() {
+	now := time.Now()
+	switch {
+	case kcpConn.kcp.dead: //重发太多次，对方已经收不到了
+		log.Debug("close conn: %v dead link", kcpConn.remoteAddr)
+		kcpConn.reason.set(CloseDeadLink)
+		kcpConn.err = errDeadLink
+		kcpConn.doDestroy()
+		return
+	case now.Sub(kcpConn.lastRecv) > kcpConn.timeout:
+		log.Debug("close conn: %v timeout", kcpConn.remoteAddr)
+		kcpConn.err = os.ErrDeadlineExceeded
+		kcpConn.doDestroy()
+		return
+	case kcpConn.closeFlag && kcpConn.kcp.waitSnd() == 0: //关闭时等数据都被确认
+		kcpConn.doDestroy()
+		return
+	}
+
+	if now.Sub(kcpConn.lastSend) >= kcpConn.timeout/3 { //心跳，告诉对方自己的窗口
+		kcpConn.kcp.probe |= kcpAskTell
+	}
+	kcpConn.kcp.update(kcpConn.clock())
+}
+
+// goroutine safe
+//处理收到的UDP报文，data在返回后可以复用
+func (kcpConn *KCPConn) input(data []byte) {
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+	if kcpConn.destroyed {
+		return
+	}
+
+	kcpConn.kcp.current = kcpConn.clock()
+	if err := kcpConn.kcp.input(data); err != nil {
+		log.Debug("%v: %v", kcpConn.remoteAddr, err)
+		return
+	}
+	kcpConn.lastRecv = time.Now()
+	kcpConn.cond.Broadcast()
+}
+
+// goroutine safe
+//发送询问窗口的段，客户端用它开始会话，不需要先发送消息
+func (kcpConn *KCPConn) askWindow() {
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+	if kcpConn.closeFlag {
+		return
+	}
+
+	kcpConn.kcp.probe |= kcpAskSend
+	kcpConn.kcp.current = kcpConn.clock()
+	kcpConn.kcp.flush()
+}
+
+//做销毁操作
+func (kcpConn *KCPConn) doDestroy() {
+	if kcpConn.destroyed {
+		return
+	}
+
+	kcpConn.destroyed = true
+	kcpConn.closeFlag = true
+	close(kcpConn.done)      //停止刷新
+	kcpConn.cond.Broadcast() //唤醒等待消息的goroutine
+	if kcpConn.release != nil {
+		kcpConn.release()
+	}
+}
+
+//销毁，丢弃未发送和未被确认的数据
+func (kcpConn *KCPConn) Destroy() {
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+
+	kcpConn.doDestroy()
+}
+
+//关闭连接，等已发送的消息都被确认后再销毁
+//关闭后ReadMsg马上返回错误，之后收到的消息不再交给代理
+func (kcpConn *KCPConn) Close() {
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+
+	kcpConn.closeFlag = true
+	kcpConn.cond.Broadcast() //唤醒等待消息的goroutine
+}
+
+//返回本地地址
+func (kcpConn *KCPConn) LocalAddr() net.Addr {
+	return kcpConn.localAddr
+}
+
+//返回远程地址
+func (kcpConn *KCPConn) RemoteAddr() net.Addr {
+	return kcpConn.remoteAddr
+}
+
+//连接关闭的原因
+func (kcpConn *KCPConn) CloseReason() CloseReason {
+	return kcpConn.reason.get()
+}
+
+//记录连接关闭的原因，只有第一次记录有效
+func (kcpConn *KCPConn) SetCloseReason(reason CloseReason) {
+	kcpConn.reason.set(reason)
+}
+
+//读取的消息不是从缓冲区池取得的，不需要放回
+func (kcpConn *KCPConn) ReleaseMsg(b []byte) {}
+
+//不区分优先级，和WriteMsg相同
+func (kcpConn *KCPConn) WriteMsgPriority(priority Priority, args ...[]byte) error {
+	return kcpConn.WriteMsg(args...)
+}
+
+// goroutine not safe
+//读取消息，没有完整的消息时阻塞，连接销毁后返回错误
+func (kcpConn *KCPConn) ReadMsg() ([]byte, error) {
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+
+	for {
+		if kcpConn.destroyed && kcpConn.err != nil {
+			kcpConn.reason.set(CloseReasonOf(kcpConn.err))
+			return nil, kcpConn.err
+		}
+		if kcpConn.closeFlag {
+			return nil, errors.New("connection closed")
+		}
+		if b := kcpConn.kcp.recv(); b != nil {
+			if uint32(len(b)) > kcpConn.maxMsgLen {
+				kcpConn.reason.set(CloseMsgTooLong)
+				return nil, errMsgTooLong
+			}
+			return b, nil
+		}
+		kcpConn.cond.Wait()
+	}
+}
+
+//发送消息，马上刷新以降低延迟
+func (kcpConn *KCPConn) WriteMsg(args ...[]byte) error {
+	//计算长度
+	var msgLen uint32
+	for i := 0; i < len(args); i++ {
+		msgLen += uint32(len(args[i]))
+	}
+
+	//检查长度
+	if msgLen > kcpConn.maxMsgLen {
+		return errors.New("message too long")
+	} else if msgLen < 1 {
+		return errors.New("message too short")
+	}
+
+	//合并多个切片，KCP会拷贝数据
+	msg := args[0]
+	if len(args) > 1 {
+		msg = make([]byte, 0, msgLen)
+		for i := 0; i < len(args); i++ {
+			msg = append(msg, args[i]...)
+		}
+	}
+
+	kcpConn.Lock()
+	defer kcpConn.Unlock()
+	if kcpConn.closeFlag {
+		return nil
+	}
+
+	if kcpConn.kcp.waitSnd() >= kcpConn.pendingWriteNum { //对方太久没有确认
+		log.Debug("close conn: send window full")
+		kcpConn.reason.set(CloseWriteOverflow)
+		kcpConn.doDestroy()
+		return nil
+	}
+	if err := kcpConn.kcp.send(msg); err != nil {
+		return err
+	}
+	kcpConn.kcp.current = kcpConn.clock()
+	kcpConn.kcp.flush()
+	return nil
+}
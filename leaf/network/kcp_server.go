@@ -0,0 +1,257 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
+	"net"
+	"sync"
+	"time"
+)
+
+//KCP服务器类型定义，所有连接共用一个UDP端口，按对方地址区分连接
+type KCPServer struct {
+	Addr            string               //地址
+	MaxConnNum      int                  //最大连接数
+	PendingWriteNum int                  //每个连接等待发送和确认的最大段数，超过时断开连接
+	MaxMsgLen       uint32               //最大消息长度
+	CloseTimeout    time.Duration        //关闭时等待已发送的消息被确认的时间，超时后强制关闭，为0时立即关闭
+	NewAgent        func(*KCPConn) Agent //创建代理函数
+	Filter          *IPFilter            //IP过滤器，为nil不过滤
+	MaxConnPerIP    int                  //每个IP的最大连接数，为0不限，对方可以用不同的端口和conv创建多个会话
+	Config          KCPConfig            //KCP配置
+	PacketConn      net.PacketConn       //使用已有的PacketConn，为nil时监听Addr，可以用来包装底层的收发
+	pc              net.PacketConn       //底层的UDP连接
+	conns           map[string]*KCPConn  //连接集合，对方地址->KCP连接
+	ipConns         map[string]int       //IP->连接数
+	mutexConns      sync.Mutex           //互斥锁
+	wg              sync.WaitGroup       //等待组
+	closeFlag       bool                 //关闭标志
+	readDone        chan struct{}        //接收goroutine退出时关闭
+}
+
+//启动KCP服务器
+func (server *KCPServer) Start() {
+	server.init()
+	go server.run()
+}
+
+//初始化KCP服务器
+func (server *KCPServer) init() {
+	pc := server.PacketConn
+	if pc == nil {
+		var err error
+		pc, err = net.ListenPacket("udp", server.Addr)
+		if err != nil {
+			log.Fatal("%v", err)
+		}
+	}
+
+	if server.MaxConnNum <= 0 {
+		server.MaxConnNum = 100
+		log.Release("invalid MaxConnNum, reset to %v", server.MaxConnNum)
+	}
+	if server.PendingWriteNum <= 0 {
+		server.PendingWriteNum = 100
+		log.Release("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
+	}
+	if server.MaxMsgLen == 0 {
+		server.MaxMsgLen = 4096
+		log.Release("invalid MaxMsgLen, reset to %v", server.MaxMsgLen)
+	}
+	if server.NewAgent == nil {
+		log.Fatal("NewAgent must not be nil")
+	}
+
+	server.pc = pc
+	server.conns = make(map[string]*KCPConn)
+	server.ipConns = make(map[string]int)
+	server.closeFlag = false
+	server.readDone = make(chan struct{})
+
+	if metrics.Enabled() { //统计连接数
+		metrics.RegisterGaugeFunc("leaf_network_connections", "Number of connections accepted by the server.", func() float64 {
+			server.mutexConns.Lock()
+			defer server.mutexConns.Unlock()
+			return float64(len(server.conns))
+		}, "addr", server.Addr)
+	}
+}
+
+//运行KCP服务器，接收所有UDP报文并交给对应的连接
+func (server *KCPServer) run() {
+	defer close(server.readDone)
+
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := server.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Error("read error: %v", err)
+			continue
+		}
+		data := buf[:n]
+
+		key := addr.String()
+		server.mutexConns.Lock()
+		kcpConn, ok := server.conns[key]
+		if !ok {
+			kcpConn = server.accept(addr, data)
+		}
+		server.mutexConns.Unlock()
+		if kcpConn == nil {
+			continue
+		}
+
+		if !ok {
+			server.serve(key, addr, kcpConn)
+		}
+		kcpConn.input(data)
+	}
+}
+
+//新的对方地址，报文可以开始一个会话时创建连接，调用前需要加锁
+func (server *KCPServer) accept(addr net.Addr, data []byte) *KCPConn {
+	if server.closeFlag || !kcpSessionStart(data, server.Config.recvWnd()) { //旧会话的报文，对方会因为收不到确认而断开
+		return nil
+	}
+
+	ip := udpIP(addr)
+	if server.Filter != nil && ip != nil {
+		if err := server.Filter.Check(ip); err != nil {
+			log.Debug("refuse %v: %v", ip, err)
+			return nil
+		}
+	}
+	if len(server.conns) >= server.MaxConnNum {
+		log.Debug("too many connections")
+		return nil
+	}
+	if server.MaxConnPerIP > 0 && ip != nil && server.ipConns[ip.String()] >= server.MaxConnPerIP { //同一个IP的会话太多
+		log.Debug("too many connections from %v", ip)
+		return nil
+	}
+	if ip != nil {
+		server.ipConns[ip.String()]++
+	}
+
+	pc := server.pc
+	conv := binary.LittleEndian.Uint32(data)
+	kcpConn := newKCPConn(conv, &server.Config, pc.LocalAddr(), addr, server.PendingWriteNum, server.MaxMsgLen,
+		func(b []byte) {
+			pc.WriteTo(b, addr)
+		}, nil)
+	server.conns[addr.String()] = kcpConn
+	server.wg.Add(1)
+
+	return kcpConn
+}
+
+//在一个新的goroutine中运行新连接的代理
+func (server *KCPServer) serve(key string, addr net.Addr, kcpConn *KCPConn) {
+	agent := server.NewAgent(kcpConn)
+	go func() {
+		agent.Run()
+
+		//清理工作，等连接销毁后再删除，期间继续接收对方的确认
+		kcpConn.Close()
+		agent.OnClose()
+		<-kcpConn.done
+		server.mutexConns.Lock()
+		if server.conns[key] == kcpConn {
+			delete(server.conns, key)
+		}
+		if ip := udpIP(addr); ip != nil {
+			server.releaseIP(ip.String())
+		}
+		server.mutexConns.Unlock()
+
+		server.wg.Done()
+	}()
+}
+
+//减少IP的连接数，调用前需要加锁
+func (server *KCPServer) releaseIP(ip string) {
+	if n := server.ipConns[ip]; n > 1 {
+		server.ipConns[ip] = n - 1
+	} else {
+		delete(server.ipConns, ip)
+	}
+}
+
+//UDP地址的IP
+func udpIP(addr net.Addr) net.IP {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP
+	}
+	return hostIP(addr.String())
+}
+
+//报文是否可以开始一个会话：格式正确，并且有新会话第一个接收窗口内的数据段或者询问窗口的段
+//客户端先发送的数据段丢失时，之后的数据段也能开始会话，等待服务器先发送消息的客户端需要先发送一个询问窗口的段
+//确认和告知窗口的段只属于已有的会话，不会开始新的会话
+func kcpSessionStart(data []byte, window uint32) bool {
+	if len(data) < kcpOverhead {
+		return false
+	}
+	conv := binary.LittleEndian.Uint32(data)
+	start := false
+	for len(data) >= kcpOverhead {
+		cmd := data[4]
+		sn := binary.LittleEndian.Uint32(data[12:])
+		length := binary.LittleEndian.Uint32(data[20:])
+		if binary.LittleEndian.Uint32(data) != conv {
+			return false
+		}
+		switch cmd {
+		case kcpCmdPush:
+			if sn < window {
+				start = true
+			}
+		case kcpCmdWask:
+			start = true
+		case kcpCmdAck, kcpCmdWins:
+		default:
+			return false
+		}
+		data = data[kcpOverhead:]
+		if uint32(len(data)) < length {
+			return false
+		}
+		data = data[length:]
+	}
+	return start
+}
+
+//关闭KCP服务器
+func (server *KCPServer) Close() {
+	server.mutexConns.Lock()
+	server.closeFlag = true
+	conns := make([]*KCPConn, 0, len(server.conns))
+	for _, kcpConn := range server.conns {
+		conns = append(conns, kcpConn)
+	}
+	server.mutexConns.Unlock()
+
+	for _, kcpConn := range conns {
+		if server.CloseTimeout > 0 {
+			kcpConn.Close() //已发送的消息被确认后再关闭
+		} else {
+			kcpConn.Destroy()
+		}
+	}
+
+	if server.CloseTimeout > 0 && !waitTimeout(&server.wg, server.CloseTimeout) {
+		log.Release("close timeout, destroy %v connections", len(conns))
+		for _, kcpConn := range conns {
+			kcpConn.Destroy()
+		}
+	}
+	server.wg.Wait()
+
+	server.pc.Close() //连接都销毁后再关闭，之前还需要接收确认
+	<-server.readDone
+}
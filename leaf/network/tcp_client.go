@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"github.com/name5566/leaf/log"
 	"net"
 	"sync"
@@ -13,7 +14,10 @@ type TCPClient struct {
 	ConnNum         int
 	ConnectInterval time.Duration
 	PendingWriteNum int
+	AutoReconnect   bool
 	NewAgent        func(*TCPConn) Agent
+	TLS             *TLSConfig //TLS配置，为nil不使用TLS
+	tlsConfig       *tls.Config
 	conns           ConnSet
 	wg              sync.WaitGroup
 	closeFlag       bool
@@ -30,6 +34,7 @@ func (client *TCPClient) Start() {
 	client.init()
 
 	for i := 0; i < client.ConnNum; i++ {
+		client.wg.Add(1)
 		go client.connect()
 	}
 }
@@ -56,6 +61,13 @@ func (client *TCPClient) init() {
 	if client.conns != nil {
 		log.Fatal("client is running")
 	}
+	if client.TLS != nil {
+		config, err := client.TLS.clientConfig(client.Addr)
+		if err != nil {
+			log.Fatal("%v", err)
+		}
+		client.tlsConfig = config
+	}
 
 	client.conns = make(ConnSet)
 	client.closeFlag = false
@@ -64,12 +76,13 @@ func (client *TCPClient) init() {
 	msgParser := NewMsgParser()
 	msgParser.SetMsgLen(client.LenMsgLen, client.MinMsgLen, client.MaxMsgLen)
 	msgParser.SetByteOrder(client.LittleEndian)
+	msgParser.SetHeartbeat(true) //回复服务器的心跳帧
 	client.msgParser = msgParser
 }
 
 func (client *TCPClient) dial() net.Conn {
 	for {
-		conn, err := net.Dial("tcp", client.Addr)
+		conn, err := client.doDial()
 		if err == nil || client.closeFlag {
 			return conn
 		}
@@ -80,7 +93,22 @@ func (client *TCPClient) dial() net.Conn {
 	}
 }
 
+func (client *TCPClient) doDial() (net.Conn, error) {
+	if client.tlsConfig == nil {
+		return net.Dial("tcp", client.Addr)
+	}
+
+	conn, err := tls.Dial("tcp", client.Addr, client.tlsConfig)
+	if err != nil { //避免返回值为nil的*tls.Conn
+		return nil, err
+	}
+	return conn, nil
+}
+
 func (client *TCPClient) connect() {
+	defer client.wg.Done()
+
+reconnect:
 	conn := client.dial()
 	if conn == nil {
 		return
@@ -95,9 +123,8 @@ func (client *TCPClient) connect() {
 	client.conns[conn] = struct{}{}
 	client.Unlock()
 
-	client.wg.Add(1)
-
 	tcpConn := newTCPConn(conn, client.PendingWriteNum, client.msgParser)
+	tcpConn.replyPing = true
 	agent := client.NewAgent(tcpConn)
 	agent.Run()
 
@@ -110,7 +137,13 @@ func (client *TCPClient) connect() {
 	client.Unlock()
 	agent.OnClose()
 
-	client.wg.Done()
+	client.Lock()
+	closeFlag := client.closeFlag
+	client.Unlock()
+	if client.AutoReconnect && !closeFlag {
+		time.Sleep(client.ConnectInterval)
+		goto reconnect
+	}
 }
 
 func (client *TCPClient) Close() {
@@ -2,8 +2,10 @@ package network
 
 import (
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
 	"net"
 	"sync"
+	"time"
 )
 
 //连接集合
@@ -11,47 +13,100 @@ type ConnSet map[net.Conn]struct{} //值为空结构体
 
 //TCP连接类型定义
 type TCPConn struct {
-	sync.Mutex             //匿名字段
-	conn       net.Conn    //底层连接
-	writeChan  chan []byte //发送缓冲
-	closeFlag  bool        //关闭标志
-	msgParser  *MsgParser  //消息解析器
+	sync.Mutex                 //匿名字段
+	conn            net.Conn   //底层连接
+	writeQueue      writeQueue //发送缓冲区
+	writeCond       *sync.Cond //发送缓冲区有数据或者连接关闭时通知发送goroutine
+	pendingWriteNum int        //发送缓冲区的长度
+	closeFlag       bool       //关闭标志
+	msgParser       *MsgParser //消息解析器
+
+	// heartbeat 心跳
+	readTimeout time.Duration //读取一条消息的超时，为0不超时
+	replyPing   bool          //收到心跳帧时回复，客户端使用
+
+	// close reason 关闭原因
+	reason closeReason //连接关闭的原因
+
+	// buffer pool 缓冲区池
+	lenBuf [4]byte //读取len的缓冲区，只在读取的goroutine中使用
+
+	// backpressure 发送缓冲区满时的处理
+	overflow     OverflowPolicy //发送缓冲区满时的处理策略
+	blockTimeout time.Duration  //OverflowBlock最多等待的时间，为0时一直等待(TCPServer会设置默认值)
+	spillBytes   int            //OverflowSpill时发送缓冲区最多保存的字节数
+	spaceCond    *sync.Cond     //发送缓冲区有空位或者连接关闭时通知等待的goroutine
 }
 
+//发送goroutine一次最多合并的缓冲区数
+const maxWriteBatch = 64
+
 //新建TCP连接
 func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPConn {
-	tcpConn := new(TCPConn)                                //创建一个TCP连接实例
-	tcpConn.conn = conn                                    //保存底层连接
-	tcpConn.writeChan = make(chan []byte, pendingWriteNum) //创建发送缓冲区
-	tcpConn.msgParser = msgParser                          //保存消息解析器
-
-	go func() { //在一个新的goroutine中做发送数据工作
-		for b := range tcpConn.writeChan { //如果发送缓冲区被关闭，此循环会自动结束（结束阻塞），如果没有数据，会阻塞在这里
-			if b == nil { //如果收到的值为nil，而不是字节切片
-				break //中断循环
-			}
-
-			_, err := conn.Write(b) //发送数据
-			if err != nil {         //发生错误
-				break //中断循环
-			}
-		}
-		//清理工作
-		conn.Close()             //关闭底层连接
-		tcpConn.Lock()           //加锁
-		tcpConn.closeFlag = true //设置关闭标志
-		tcpConn.Unlock()         //解锁
-	}()
+	tcpConn := new(TCPConn)                          //创建一个TCP连接实例
+	tcpConn.conn = conn                              //保存底层连接
+	tcpConn.writeCond = sync.NewCond(&tcpConn.Mutex) //发送缓冲区和连接共用一个锁
+	tcpConn.spaceCond = sync.NewCond(&tcpConn.Mutex) //同上
+	tcpConn.pendingWriteNum = pendingWriteNum        //保存发送缓冲区的长度
+	tcpConn.msgParser = msgParser                    //保存消息解析器
+
+	go tcpConn.writeLoop() //在一个新的goroutine中做发送数据工作
 
 	return tcpConn
 }
 
+//发送数据，把发送缓冲区中已有的数据合并起来，用一次系统调用(writev)发送
+//发送缓冲区中的切片都来自缓冲区池，发送后放回
+func (tcpConn *TCPConn) writeLoop() {
+	batch := make([][]byte, 0, maxWriteBatch) //本次发送的切片
+	iov := make([][]byte, 0, maxWriteBatch)   //net.Buffers发送时会修改切片的内容，每次从batch复制
+	bufs := new(net.Buffers)                  //只分配一次
+
+	for {
+		tcpConn.Lock()
+		for tcpConn.writeQueue.len() == 0 && !tcpConn.closeFlag { //没有数据时等待
+			tcpConn.writeCond.Wait()
+		}
+		if tcpConn.writeQueue.len() == 0 { //已经关闭，并且数据都发送完了
+			tcpConn.Unlock()
+			break
+		}
+		//取出发送缓冲区中已有的数据
+		for len(batch) < maxWriteBatch && tcpConn.writeQueue.len() > 0 {
+			batch = append(batch, tcpConn.writeQueue.pop())
+		}
+		tcpConn.spaceCond.Broadcast() //唤醒等待空位的goroutine
+		tcpConn.Unlock()
+
+		iov = append(iov[:0], batch...)
+		*bufs = iov
+		_, err := bufs.WriteTo(tcpConn.conn) //发送数据
+		for i := range batch {
+			putBuffer(batch[i])
+			batch[i] = nil
+		}
+		batch = batch[:0]
+		if err != nil { //发生错误
+			break //中断循环
+		}
+	}
+	//清理工作
+	tcpConn.conn.Close()          //关闭底层连接
+	tcpConn.Lock()                //加锁
+	tcpConn.closeFlag = true      //设置关闭标志
+	tcpConn.writeQueue.reset()    //丢弃未发送的数据
+	tcpConn.spaceCond.Broadcast() //唤醒等待空位的goroutine
+	tcpConn.Unlock()              //解锁
+}
+
 //做销毁操作
 func (tcpConn *TCPConn) doDestroy() {
-	tcpConn.conn.(*net.TCPConn).SetLinger(0) //丢弃所有的数据
-	tcpConn.conn.Close()                     //关闭底层连接
-	close(tcpConn.writeChan)                 //关闭发送缓冲区，也会导致发送goroutine中断
-	tcpConn.closeFlag = true                 //设置关闭标记
+	setLinger0(tcpConn.conn)      //丢弃所有的数据
+	tcpConn.conn.Close()          //关闭底层连接
+	tcpConn.writeQueue.reset()    //清空发送缓冲区
+	tcpConn.closeFlag = true      //设置关闭标记
+	tcpConn.writeCond.Broadcast() //发送goroutine看到发送缓冲区为空后结束
+	tcpConn.spaceCond.Broadcast() //唤醒等待空位的goroutine
 }
 
 //销毁
@@ -73,31 +128,98 @@ func (tcpConn *TCPConn) Close() {
 		return //直接返回
 	}
 
-	tcpConn.doWrite(nil)     //发送一个nil到发送缓冲区，导致发送goroutine中断循环，做清理工作
-	tcpConn.closeFlag = true //设置关闭标志
+	tcpConn.closeFlag = true      //设置关闭标志，发送goroutine发送完已有的数据后做清理工作
+	tcpConn.writeCond.Broadcast() //通知发送goroutine
+	tcpConn.spaceCond.Broadcast() //等待空位的goroutine不再等待
 }
 
-//做写操作
-func (tcpConn *TCPConn) doWrite(b []byte) {
-	if len(tcpConn.writeChan) == cap(tcpConn.writeChan) { //如果发送缓冲区的长度等于最大容量
-		log.Debug("close conn: channel full") //日志记录，管道已满
-		tcpConn.doDestroy()                   //做销毁操作
+//做写操作，调用前需要加锁，b来自缓冲区池
+//发送缓冲区满时按overflow处理，关键消息不受发送缓冲区长度的限制
+func (tcpConn *TCPConn) doWrite(b []byte, priority Priority) {
+	critical := priority >= PriorityCritical
+	if !critical && tcpConn.writeQueue.len() >= tcpConn.pendingWriteNum && !tcpConn.makeRoom(len(b)) {
+		log.Debug("close conn: write queue full, policy %v", tcpConn.overflow) //日志记录，发送缓冲区已满
+		putBuffer(b)
+		tcpConn.reason.set(CloseWriteOverflow)
+		tcpConn.doDestroy() //做销毁操作
 		return
 	}
+	if tcpConn.closeFlag { //等待空位时连接关闭了
+		putBuffer(b)
+		return
+	}
+
+	tcpConn.writeQueue.push(b, critical) //将待发数据放到发送缓冲区
+	tcpConn.writeCond.Signal()           //通知发送goroutine
+}
 
-	tcpConn.writeChan <- b //将待发数据发送到发送缓冲区
+//发送缓冲区满时按策略腾出空位，返回false时需要销毁连接，调用前需要加锁
+func (tcpConn *TCPConn) makeRoom(n int) bool {
+	switch tcpConn.overflow {
+	case OverflowBlock:
+		return tcpConn.waitRoom()
+	case OverflowDropOldest:
+		b := tcpConn.writeQueue.dropOldest()
+		if b == nil { //全是关键消息
+			return false
+		}
+		putBuffer(b)
+		log.Debug("drop message: write queue full")
+		if metrics.Enabled() { //统计丢弃的消息数
+			metrics.GetCounter("leaf_network_messages_dropped_total", "Number of messages dropped because the write queue was full.").Inc()
+		}
+		return true
+	case OverflowSpill:
+		return tcpConn.writeQueue.bytes+n <= tcpConn.spillBytes
+	default:
+		return false
+	}
 }
 
-// b must not be modified by other goroutines
-//写入数据
+//等待发送缓冲区有空位，超过blockTimeout返回false，连接关闭时返回true，调用前需要加锁
+func (tcpConn *TCPConn) waitRoom() bool {
+	expired := false
+	if tcpConn.blockTimeout > 0 {
+		t := time.AfterFunc(tcpConn.blockTimeout, func() {
+			tcpConn.Lock()
+			expired = true
+			tcpConn.spaceCond.Broadcast()
+			tcpConn.Unlock()
+		})
+		defer t.Stop()
+	}
+
+	for tcpConn.writeQueue.len() >= tcpConn.pendingWriteNum && !tcpConn.closeFlag {
+		if expired {
+			return false
+		}
+		tcpConn.spaceCond.Wait() //等待时释放锁
+	}
+	return true
+}
+
+// b can be reused after Write returns
+//写入数据，拷贝到缓冲区池的缓冲区中发送
 func (tcpConn *TCPConn) Write(b []byte) {
-	tcpConn.Lock()                     //加锁
-	defer tcpConn.Unlock()             //延迟解锁
-	if tcpConn.closeFlag || b == nil { //如果连接已关闭或者传入的b为空
+	if b == nil { //传入的b为空
+		return //返回
+	}
+
+	buf := getBuffer(len(b))
+	copy(buf, b)
+	tcpConn.writeBuffer(buf, PriorityNormal)
+}
+
+//写入从缓冲区池取得的数据，发送后放回缓冲区池
+func (tcpConn *TCPConn) writeBuffer(b []byte, priority Priority) {
+	tcpConn.Lock()         //加锁
+	defer tcpConn.Unlock() //延迟解锁
+	if tcpConn.closeFlag { //如果连接已关闭
+		putBuffer(b)
 		return //返回
 	}
 
-	tcpConn.doWrite(b) //做具体的发送操作
+	tcpConn.doWrite(b, priority) //做具体的发送操作
 }
 
 //实现io.Reader接口
@@ -116,12 +238,63 @@ func (tcpConn *TCPConn) RemoteAddr() net.Addr {
 	return tcpConn.conn.RemoteAddr()
 }
 
-//读取消息
+//连接关闭的原因
+func (tcpConn *TCPConn) CloseReason() CloseReason {
+	return tcpConn.reason.get()
+}
+
+//记录连接关闭的原因，只有第一次记录有效
+func (tcpConn *TCPConn) SetCloseReason(reason CloseReason) {
+	tcpConn.reason.set(reason)
+}
+
+//读取消息，心跳帧不返回给调用者
 func (tcpConn *TCPConn) ReadMsg() ([]byte, error) {
-	return tcpConn.msgParser.Read(tcpConn) //使用消息解析器读取
+	for {
+		if tcpConn.readTimeout > 0 { //超时后读取返回错误
+			tcpConn.conn.SetReadDeadline(time.Now().Add(tcpConn.readTimeout))
+		}
+		b, err := tcpConn.msgParser.Read(tcpConn) //使用消息解析器读取
+		if err != nil {
+			tcpConn.reason.set(CloseReasonOf(err))
+			return nil, err
+		}
+		if len(b) > 0 {
+			return b, nil
+		}
+		if tcpConn.replyPing {
+			tcpConn.writeBuffer(tcpConn.msgParser.heartbeatFrame(), PriorityNormal)
+		}
+	}
+}
+
+//把ReadMsg返回的消息放回缓冲区池，调用后不能再使用b
+func (tcpConn *TCPConn) ReleaseMsg(b []byte) {
+	putBuffer(b)
+}
+
+//每隔interval发送一个心跳帧，连接关闭后停止
+func (tcpConn *TCPConn) ping(interval time.Duration) {
+	time.AfterFunc(interval, func() {
+		tcpConn.Lock()
+		closeFlag := tcpConn.closeFlag
+		if !closeFlag {
+			tcpConn.doWrite(tcpConn.msgParser.heartbeatFrame(), PriorityNormal)
+		}
+		tcpConn.Unlock()
+
+		if !closeFlag {
+			tcpConn.ping(interval)
+		}
+	})
 }
 
 //发送消息
 func (tcpConn *TCPConn) WriteMsg(args ...[]byte) error {
 	return tcpConn.msgParser.Write(tcpConn, args...) //使用消息解析器发送
 }
+
+//按优先级发送消息，关键消息在发送缓冲区满时也不会被丢弃
+func (tcpConn *TCPConn) WriteMsgPriority(priority Priority, args ...[]byte) error {
+	return tcpConn.msgParser.write(tcpConn, priority, args)
+}
@@ -11,11 +11,13 @@ import (
 // | len | data |
 // --------------
 //消息解析器类型定义
+//开启心跳时，len为0的帧是心跳帧，不受最小消息长度的限制
 type MsgParser struct {
 	lenMsgLen    int    //消息长度占用字节数
 	minMsgLen    uint32 //最小消息长度
 	maxMsgLen    uint32 //最大消息长度
 	littleEndian bool   //是否是小端
+	heartbeat    bool   //是否接受心跳帧
 }
 
 //创建消息解析器
@@ -66,11 +68,23 @@ func (p *MsgParser) SetByteOrder(littleEndian bool) {
 	p.littleEndian = littleEndian
 }
 
+// It's dangerous to call the method on reading or writing
+//设置是否接受心跳帧，开启后Read读到心跳帧时返回长度为0的消息
+func (p *MsgParser) SetHeartbeat(heartbeat bool) {
+	p.heartbeat = heartbeat
+}
+
+//心跳帧，只有值为0的len，从缓冲区池取得
+func (p *MsgParser) heartbeatFrame() []byte {
+	b := getBuffer(p.lenMsgLen)
+	clear(b)
+	return b
+}
+
 // goroutine safe
-//读取消息
+//读取消息，消息从缓冲区池取得，用完后可以调用conn.ReleaseMsg放回
 func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
-	var b [4]byte                //先声明一个4字节切片
-	bufMsgLen := b[:p.lenMsgLen] //根据消息长度占用字节数重新取得对应长度的切片
+	bufMsgLen := conn.lenBuf[:p.lenMsgLen] //根据消息长度占用字节数取得连接的len缓冲区，避免每次分配
 
 	// read len
 	if _, err := io.ReadFull(conn, bufMsgLen); err != nil { //读取消息长度
@@ -99,15 +113,18 @@ func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
 
 	// check len
 	//检查长度
-	if msgLen > p.maxMsgLen { //超过了最大长度
-		return nil, errors.New("message too long")
+	if msgLen == 0 && p.heartbeat { //心跳帧
+		return []byte{}, nil
+	} else if msgLen > p.maxMsgLen { //超过了最大长度
+		return nil, errMsgTooLong
 	} else if msgLen < p.minMsgLen { //小于最小长度
 		return nil, errors.New("message too short")
 	}
 
 	// data
-	msgData := make([]byte, msgLen)                       //创建对应长度的字节切片
+	msgData := getBuffer(int(msgLen))                     //从缓冲区池取得对应长度的字节切片
 	if _, err := io.ReadFull(conn, msgData); err != nil { //读取数据
+		putBuffer(msgData)
 		return nil, err
 	}
 
@@ -115,8 +132,13 @@ func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
 }
 
 // goroutine safe
-//发送消息
+//发送消息，len和所有切片拷贝到缓冲区池的一个缓冲区中，返回后args可以复用
 func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error { //传入多个字节切片
+	return p.write(conn, PriorityNormal, args)
+}
+
+//按优先级发送消息
+func (p *MsgParser) write(conn *TCPConn, priority Priority, args [][]byte) error {
 	// get len
 	//计算长度
 	var msgLen uint32
@@ -132,7 +154,7 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error { //传入多个
 		return errors.New("message too short")
 	}
 
-	msg := make([]byte, uint32(p.lenMsgLen)+msgLen) //创建len+len(data)长度的字节切片
+	msg := getBuffer(p.lenMsgLen + int(msgLen)) //从缓冲区池取得len+len(data)长度的字节切片
 
 	// write len
 	//写入长度
@@ -161,7 +183,7 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error { //传入多个
 		l += len(args[i])      //游标
 	}
 
-	conn.Write(msg) //发送数据
+	conn.writeBuffer(msg, priority) //发送数据，发送后放回缓冲区池
 
 	return nil
 }
@@ -1,22 +1,41 @@
 package network
 
 import (
+	"crypto/tls"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
 	"net"
 	"sync"
+	"time"
 )
 
 //TCP服务器类型定义
 type TCPServer struct {
-	Addr            string               //地址
-	MaxConnNum      int                  //最大连接数
-	PendingWriteNum int                  //发送缓冲区长度
-	NewAgent        func(*TCPConn) Agent //创建代理函数
-	ln              net.Listener         //监听连接器
-	conns           ConnSet              //连接集合
-	mutexConns      sync.Mutex           //互斥锁
-	wg              sync.WaitGroup       //等待组
-	closeFlag       bool                 //关闭标志
+	Addr            string                //地址
+	MaxConnNum      int                   //最大连接数
+	PendingWriteNum int                   //发送缓冲区长度
+	CloseTimeout    time.Duration         //关闭时等待发送缓冲区写完的时间，超时后强制关闭，为0时立即关闭
+	NewAgent        func(*TCPConn) Agent  //创建代理函数
+	TLS             *TLSConfig            //TLS配置，为nil不使用TLS
+	ln              net.Listener          //监听连接器
+	conns           map[net.Conn]*TCPConn //连接集合，底层连接->TCP连接
+	mutexConns      sync.Mutex            //互斥锁
+	wg              sync.WaitGroup        //等待组
+	closeFlag       bool                  //关闭标志
+
+	// ip filter IP过滤
+	MaxConnPerIP int            //每个IP的最大连接数，为0不限
+	Filter       *IPFilter      //IP过滤器，为nil不过滤，可以使用DefaultIPFilter
+	ipConns      map[string]int //IP->连接数
+
+	// heartbeat 心跳
+	ReadTimeout  time.Duration //多久没有收到消息(包括心跳帧)就断开连接，为0不检查
+	PingInterval time.Duration //发送心跳帧的间隔，客户端需要回复心跳帧，为0不发送
+
+	// backpressure 发送缓冲区满时的处理
+	OverflowPolicy OverflowPolicy //发送缓冲区满时的处理策略，默认断开连接，关键消息(PriorityCritical)不受限制
+	BlockTimeout   time.Duration  //OverflowBlock最多等待的时间，超时后断开连接，为0时使用默认值
+	SpillBytes     int            //OverflowSpill时每个连接的发送缓冲区最多保存的字节数，超过时断开连接
 
 	// msg parser 消息解析器
 	LenMsgLen    int        //消息长度的长度(len)
@@ -47,19 +66,44 @@ func (server *TCPServer) init() {
 		server.PendingWriteNum = 100
 		log.Release("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
 	}
+	if server.OverflowPolicy == OverflowBlock && server.BlockTimeout <= 0 { //不能一直等待，否则发送消息的goroutine可能永远阻塞
+		server.BlockTimeout = 5 * time.Second
+		log.Release("invalid BlockTimeout, reset to %v", server.BlockTimeout)
+	}
+	if server.OverflowPolicy == OverflowSpill && server.SpillBytes <= 0 {
+		server.SpillBytes = 1 << 20
+		log.Release("invalid SpillBytes, reset to %v", server.SpillBytes)
+	}
 	if server.NewAgent == nil { //创建代理函数不能为空
 		log.Fatal("NewAgent must not be nil")
 	}
+	if server.TLS != nil { //在TCP监听器上加一层TLS
+		config, err := server.TLS.serverConfig()
+		if err != nil {
+			log.Fatal("%v", err)
+		}
+		ln = tls.NewListener(ln, config)
+	}
 
-	server.ln = ln               //保存监听连接器
-	server.conns = make(ConnSet) //创建连接集合
-	server.closeFlag = false     //关闭标志
+	server.ln = ln                             //保存监听连接器
+	server.conns = make(map[net.Conn]*TCPConn) //创建连接集合
+	server.ipConns = make(map[string]int)      //创建IP连接数集合
+	server.closeFlag = false                   //关闭标志
 
 	// msg parser
 	msgParser := NewMsgParser()                                               //创建消息解析器
 	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen) //设置消息长度
 	msgParser.SetByteOrder(server.LittleEndian)                               //设置字节序
+	msgParser.SetHeartbeat(server.ReadTimeout > 0 || server.PingInterval > 0) //客户端可以用心跳帧保持连接
 	server.msgParser = msgParser                                              //保存消息解析器
+
+	if metrics.Enabled() { //统计连接数
+		metrics.RegisterGaugeFunc("leaf_network_connections", "Number of connections accepted by the server.", func() float64 {
+			server.mutexConns.Lock()
+			defer server.mutexConns.Unlock()
+			return float64(len(server.conns))
+		}, "addr", server.Addr)
+	}
 }
 
 //运行TCP服务器
@@ -75,6 +119,15 @@ func (server *TCPServer) run() {
 			}
 		}
 
+		ip := remoteIP(conn)
+		if server.Filter != nil && ip != nil { //在创建代理之前过滤IP
+			if err := server.Filter.Check(ip); err != nil {
+				conn.Close()
+				log.Debug("refuse %v: %v", ip, err)
+				continue
+			}
+		}
+
 		server.mutexConns.Lock()                    //加锁，为什么要加锁，因为会从不同的goroutine中访问server.conns,比如从外部goroutine中调用server.Close或者在新的goroutine中运行代理执行清理工作的时候或者当前for循环所在goroutine中增加连接记录
 		if len(server.conns) >= server.MaxConnNum { //如果当前连接数超过上限
 			server.mutexConns.Unlock()        //解锁
@@ -82,15 +135,31 @@ func (server *TCPServer) run() {
 			log.Debug("too many connections") //日志记录：太多连接了
 			continue                          //继续循环
 		}
+		if server.MaxConnPerIP > 0 && ip != nil && server.ipConns[ip.String()] >= server.MaxConnPerIP { //同一个IP的连接太多
+			server.mutexConns.Unlock()
+			conn.Close()
+			log.Debug("too many connections from %v", ip)
+			continue
+		}
+		if ip != nil {
+			server.ipConns[ip.String()]++
+		}
+		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.msgParser) //创建一个TCP连接(原有net.Conn的封装)
+		tcpConn.readTimeout = server.ReadTimeout
+		tcpConn.overflow = server.OverflowPolicy
+		tcpConn.blockTimeout = server.BlockTimeout
+		tcpConn.spillBytes = server.SpillBytes
+		if server.PingInterval > 0 {
+			tcpConn.ping(server.PingInterval)
+		}
 		//增加连接记录
-		server.conns[conn] = struct{}{} //struct{}为类型，第二个{}为初始化，只不过是空值而已
-		server.mutexConns.Unlock()      //解锁
+		server.conns[conn] = tcpConn
+		server.mutexConns.Unlock() //解锁
 
 		server.wg.Add(1) //等待组+1
 
-		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.msgParser) //创建一个TCP连接(原有net.Conn的封装)
-		agent := server.NewAgent(tcpConn)                                     //调用注册的创建代理函数创建代理
-		go func() {                                                           //此处形成闭包
+		agent := server.NewAgent(tcpConn) //调用注册的创建代理函数创建代理
+		go func() {                       //此处形成闭包
 			agent.Run() //在一个新的goroutine中运行代理，一个客户端一个agent
 			//执行到这里时agent.Run for循环结束
 			// cleanup
@@ -98,6 +167,9 @@ func (server *TCPServer) run() {
 			tcpConn.Close()            //关闭连接（封装层）
 			server.mutexConns.Lock()   //加锁
 			delete(server.conns, conn) //从连接集合中删除连接
+			if ip != nil {
+				server.releaseIP(ip.String())
+			}
 			server.mutexConns.Unlock() //解锁
 			agent.OnClose()            //关闭代理
 
@@ -106,18 +178,55 @@ func (server *TCPServer) run() {
 	}
 }
 
+//减少IP的连接数，调用前需要加锁
+func (server *TCPServer) releaseIP(ip string) {
+	if n := server.ipConns[ip]; n > 1 {
+		server.ipConns[ip] = n - 1
+	} else {
+		delete(server.ipConns, ip)
+	}
+}
+
 //关闭TCP服务器函数
 //疑问？如果关闭了TCP服务器，那创建的那些与客户端的连接是如何关闭的
 func (server *TCPServer) Close() {
 	server.closeFlag = true //设置关闭标记
 	server.ln.Close()       //关闭监听器,导致再Accept时出错
 
-	server.mutexConns.Lock()            //加锁
-	for conn, _ := range server.conns { //遍历现有连接,则会导致所有agent循环读取数据时异常，退出循环
-		conn.Close() //关闭连接(底层)
+	server.mutexConns.Lock() //加锁
+	conns := server.conns
+	for conn, tcpConn := range conns { //遍历现有连接,则会导致所有agent循环读取数据时异常，退出循环
+		if server.CloseTimeout > 0 {
+			tcpConn.Close() //发送完缓冲区中的数据后再关闭
+		} else {
+			conn.Close() //关闭连接(底层)
+		}
+	}
+	server.conns = make(map[net.Conn]*TCPConn) //重置连接集合
+	server.mutexConns.Unlock()                 //解锁
+
+	if server.CloseTimeout > 0 && !waitTimeout(&server.wg, server.CloseTimeout) {
+		log.Release("close timeout, destroy %v connections", len(conns))
+		for conn := range conns { //强制关闭还没有写完的连接
+			setLinger0(conn)
+			conn.Close()
+		}
 	}
-	server.conns = make(ConnSet) //重置连接集合
-	server.mutexConns.Unlock()   //解锁
-
 	server.wg.Wait() //等待所有goroutine退出
 }
+
+//等待wg，超时返回false
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
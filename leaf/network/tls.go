@@ -0,0 +1,124 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+)
+
+//TLS配置，TCPServer和TCPClient共用，TCPConn在TLS连接上照常收发消息
+type TLSConfig struct {
+	CertFile           string //证书文件，服务器必须设置，客户端设置后用于客户端证书验证
+	KeyFile            string //私钥文件
+	CAFile             string //CA证书文件，服务器用于验证客户端证书，客户端用于验证服务器证书，为空时客户端使用系统的CA
+	ClientAuth         bool   //服务器是否要求并验证客户端证书，需要设置CAFile
+	ServerName         string //客户端验证的服务器名字，为空时使用地址中的主机名
+	MinVersion         string //最低版本，"1.0"、"1.1"、"1.2"或者"1.3"，默认"1.2"
+	InsecureSkipVerify bool   //客户端不验证服务器证书，只用于测试
+}
+
+func (c *TLSConfig) minVersion() (uint16, error) {
+	switch c.MinVersion {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, errors.New("unknown tls version: " + c.MinVersion)
+	}
+}
+
+//读取CA证书
+func (c *TLSConfig) certPool() (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificate found in " + c.CAFile)
+	}
+	return pool, nil
+}
+
+//服务器使用的tls.Config
+func (c *TLSConfig) serverConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("tls CertFile and KeyFile must be set")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	version, err := c.minVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+	}
+	if c.ClientAuth {
+		if c.CAFile == "" {
+			return nil, errors.New("tls CAFile must be set to verify client certificates")
+		}
+		config.ClientCAs, err = c.certPool()
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+//客户端使用的tls.Config，addr为服务器地址
+func (c *TLSConfig) clientConfig(addr string) (*tls.Config, error) {
+	version, err := c.minVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		MinVersion:         version,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerName = host
+	}
+	if c.CAFile != "" {
+		config.RootCAs, err = c.certPool()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.CertFile != "" && c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+//关闭时丢弃未发送的数据，TLS连接作用在底层的TCP连接上
+func setLinger0(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+}
@@ -0,0 +1,109 @@
+package network
+
+//发送缓冲区满(PendingWriteNum条消息)时的处理策略，只对TCPConn有效
+type OverflowPolicy int
+
+const (
+	OverflowDisconnect OverflowPolicy = iota //销毁连接，丢弃所有未发送的数据，默认的策略
+	OverflowBlock                            //发送消息的goroutine等待发送缓冲区有空位，超时后销毁连接
+	OverflowDropOldest                       //丢弃最早的一条普通消息，没有普通消息时销毁连接
+	OverflowSpill                            //超出的消息继续排队，条数不限，总字节数超过限制时销毁连接
+)
+
+func (policy OverflowPolicy) String() string {
+	switch policy {
+	case OverflowDisconnect:
+		return "disconnect"
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop oldest"
+	case OverflowSpill:
+		return "spill"
+	default:
+		return "unknown"
+	}
+}
+
+//消息优先级
+type Priority int
+
+const (
+	PriorityNormal   Priority = iota //普通消息，发送缓冲区满时按OverflowPolicy处理
+	PriorityCritical                 //关键消息，不受发送缓冲区长度的限制，不会被丢弃，例如通知客户端断开原因的消息
+)
+
+//发送缓冲区中的一条消息
+type writeItem struct {
+	b        []byte //来自缓冲区池
+	critical bool   //是否是关键消息
+}
+
+//发送缓冲区，先进先出，关键消息不会被dropOldest丢弃
+//goroutine not safe
+type writeQueue struct {
+	items []writeItem //消息，head之前的已经取出
+	head  int         //第一条消息的下标
+	bytes int         //所有消息的总字节数
+}
+
+//消息条数
+func (q *writeQueue) len() int {
+	return len(q.items) - q.head
+}
+
+//在末尾加入一条消息
+func (q *writeQueue) push(b []byte, critical bool) {
+	if len(q.items) == cap(q.items) && q.head > 0 { //移到前面，复用已经取出的位置
+		n := copy(q.items, q.items[q.head:])
+		clear(q.items[n:])
+		q.items = q.items[:n]
+		q.head = 0
+	}
+	q.items = append(q.items, writeItem{b: b, critical: critical})
+	q.bytes += len(b)
+}
+
+//取出第一条消息，调用前需要确认不为空
+func (q *writeQueue) pop() []byte {
+	b := q.items[q.head].b
+	q.items[q.head] = writeItem{}
+	q.advance(len(b))
+	return b
+}
+
+//丢弃最早的一条普通消息，返回被丢弃的数据，没有普通消息时返回nil
+func (q *writeQueue) dropOldest() []byte {
+	for i := q.head; i < len(q.items); i++ {
+		if q.items[i].critical {
+			continue
+		}
+		b := q.items[i].b
+		copy(q.items[q.head+1:i+1], q.items[q.head:i]) //前面的关键消息后移一位
+		q.items[q.head] = writeItem{}
+		q.advance(len(b))
+		return b
+	}
+	return nil
+}
+
+//第一条消息被取出后调用
+func (q *writeQueue) advance(n int) {
+	q.head++
+	q.bytes -= n
+	if q.head == len(q.items) { //空了，从头开始
+		q.items = q.items[:0]
+		q.head = 0
+	}
+}
+
+//清空，所有数据放回缓冲区池
+func (q *writeQueue) reset() {
+	for i := q.head; i < len(q.items); i++ {
+		putBuffer(q.items[i].b)
+	}
+	clear(q.items)
+	q.items = q.items[:0]
+	q.head = 0
+	q.bytes = 0
+}
@@ -0,0 +1,213 @@
+package network
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/name5566/leaf/log"
+	"net"
+	"sync"
+	"time"
+)
+
+//WebSocket连接集合，底层连接->WebSocket连接
+type WebsocketConnSet map[*websocket.Conn]*WSConn
+
+//WebSocket连接类型定义
+type WSConn struct {
+	sync.Mutex                 //匿名字段
+	conn       *websocket.Conn //底层连接
+	writeChan  chan []byte     //发送缓冲
+	maxMsgLen  uint32          //最大消息长度
+	closeFlag  bool            //关闭标志
+
+	// heartbeat 心跳
+	readTimeout time.Duration //读取一条消息的超时，为0不超时，收到pong时也会延长
+
+	// close reason 关闭原因
+	reason closeReason //连接关闭的原因
+}
+
+//新建WebSocket连接
+func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSConn {
+	wsConn := new(WSConn)                                 //创建WebSocket连接实例
+	wsConn.conn = conn                                    //保存底层连接
+	wsConn.writeChan = make(chan []byte, pendingWriteNum) //创建发送缓冲区
+	wsConn.maxMsgLen = maxMsgLen                          //保存最大消息长度
+
+	go func() { //在一个新的goroutine中做发送数据工作
+		for b := range wsConn.writeChan {
+			if b == nil { //收到nil，中断循环
+				break
+			}
+
+			err := conn.WriteMessage(websocket.BinaryMessage, b) //一条WebSocket消息对应一条leaf消息，不需要len字段
+			if err != nil {
+				break
+			}
+		}
+		//清理工作
+		conn.Close()
+		wsConn.Lock()
+		wsConn.closeFlag = true
+		wsConn.Unlock()
+	}()
+
+	return wsConn
+}
+
+//做销毁操作
+func (wsConn *WSConn) doDestroy() {
+	if tcpConn, ok := wsConn.conn.UnderlyingConn().(*net.TCPConn); ok { //丢弃所有的数据
+		tcpConn.SetLinger(0)
+	}
+	wsConn.conn.Close()
+
+	if !wsConn.closeFlag {
+		close(wsConn.writeChan) //关闭发送缓冲区，也会导致发送goroutine中断
+		wsConn.closeFlag = true
+	}
+}
+
+//销毁
+func (wsConn *WSConn) Destroy() {
+	wsConn.Lock()
+	defer wsConn.Unlock()
+
+	wsConn.doDestroy()
+}
+
+//关闭连接
+func (wsConn *WSConn) Close() {
+	wsConn.Lock()
+	defer wsConn.Unlock()
+	if wsConn.closeFlag {
+		return
+	}
+
+	wsConn.doWrite(nil) //发送一个nil到发送缓冲区，导致发送goroutine中断循环，做清理工作
+	wsConn.closeFlag = true
+}
+
+//做写操作
+func (wsConn *WSConn) doWrite(b []byte) {
+	if len(wsConn.writeChan) == cap(wsConn.writeChan) { //发送缓冲区已满
+		log.Debug("close conn: channel full")
+		wsConn.reason.set(CloseWriteOverflow)
+		wsConn.doDestroy()
+		return
+	}
+
+	wsConn.writeChan <- b
+}
+
+//返回本地地址
+func (wsConn *WSConn) LocalAddr() net.Addr {
+	return wsConn.conn.LocalAddr()
+}
+
+//返回远程(客户端)地址
+func (wsConn *WSConn) RemoteAddr() net.Addr {
+	return wsConn.conn.RemoteAddr()
+}
+
+// goroutine not safe
+//读取消息，超过最大长度的消息由底层连接的ReadLimit拦截
+func (wsConn *WSConn) ReadMsg() ([]byte, error) {
+	if wsConn.readTimeout > 0 { //超时后读取返回错误
+		wsConn.conn.SetReadDeadline(time.Now().Add(wsConn.readTimeout))
+	}
+	_, b, err := wsConn.conn.ReadMessage()
+	if err != nil {
+		wsConn.reason.set(CloseReasonOf(err))
+	}
+	return b, err
+}
+
+//连接关闭的原因
+func (wsConn *WSConn) CloseReason() CloseReason {
+	return wsConn.reason.get()
+}
+
+//记录连接关闭的原因，只有第一次记录有效
+func (wsConn *WSConn) SetCloseReason(reason CloseReason) {
+	wsConn.reason.set(reason)
+}
+
+//读取的消息不是从缓冲区池取得的，不需要放回
+func (wsConn *WSConn) ReleaseMsg(b []byte) {}
+
+//不区分优先级，和WriteMsg相同
+func (wsConn *WSConn) WriteMsgPriority(priority Priority, args ...[]byte) error {
+	return wsConn.WriteMsg(args...)
+}
+
+//设置读取超时，需要在读取之前调用
+func (wsConn *WSConn) setReadTimeout(d time.Duration) {
+	wsConn.readTimeout = d
+	wsConn.conn.SetPongHandler(func(string) error { //在ReadMessage中调用
+		return wsConn.conn.SetReadDeadline(time.Now().Add(d))
+	})
+}
+
+//每隔interval发送一个ping控制帧，连接关闭后停止
+func (wsConn *WSConn) ping(interval time.Duration) {
+	var f func()
+	f = func() {
+		wsConn.Lock()
+		closeFlag := wsConn.closeFlag
+		wsConn.Unlock()
+		if closeFlag {
+			return
+		}
+
+		//控制帧可以和其它写操作并发发送
+		wsConn.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval))
+		time.AfterFunc(interval, f)
+	}
+	time.AfterFunc(interval, f)
+}
+
+// args must not be modified by the others goroutines
+//发送消息
+func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
+	wsConn.Lock()
+	defer wsConn.Unlock()
+	if wsConn.closeFlag {
+		return nil
+	}
+
+	// get len
+	//计算长度
+	var msgLen uint32
+	for i := 0; i < len(args); i++ {
+		msgLen += uint32(len(args[i]))
+	}
+
+	// check len
+	//检查长度
+	if msgLen > wsConn.maxMsgLen {
+		return errors.New("message too long")
+	} else if msgLen < 1 {
+		return errors.New("message too short")
+	}
+
+	// don't copy
+	//只有一个切片时不拷贝
+	if len(args) == 1 {
+		wsConn.doWrite(args[0])
+		return nil
+	}
+
+	// merge the args
+	//合并多个切片
+	msg := make([]byte, msgLen)
+	l := 0
+	for i := 0; i < len(args); i++ {
+		copy(msg[l:], args[i])
+		l += len(args[i])
+	}
+
+	wsConn.doWrite(msg)
+
+	return nil
+}
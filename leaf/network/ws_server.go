@@ -0,0 +1,231 @@
+package network
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/metrics"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//WebSocket服务器类型定义
+type WSServer struct {
+	Addr            string              //地址
+	MaxConnNum      int                 //最大连接数
+	PendingWriteNum int                 //发送缓冲区长度
+	MaxMsgLen       uint32              //最大消息长度
+	HTTPTimeout     time.Duration       //HTTP读写超时
+	CloseTimeout    time.Duration       //关闭时等待发送缓冲区写完的时间，超时后强制关闭，为0时立即关闭
+	NewAgent        func(*WSConn) Agent //创建代理函数
+	ln              net.Listener        //监听连接器
+	handler         *WSHandler          //HTTP处理器
+
+	// ip limit IP限制，在升级为WebSocket连接之前检查
+	MaxConnPerIP int       //每个IP的最大连接数，为0不限
+	Filter       *IPFilter //IP过滤器，为nil不过滤，可以使用DefaultIPFilter
+
+	// heartbeat 心跳
+	ReadTimeout  time.Duration //多久没有收到消息(包括pong控制帧)就断开连接，为0不检查
+	PingInterval time.Duration //发送ping控制帧的间隔，浏览器会自动回复，为0不发送
+}
+
+//WebSocket HTTP处理器类型定义
+type WSHandler struct {
+	maxConnNum      int                 //最大连接数
+	pendingWriteNum int                 //发送缓冲区长度
+	maxMsgLen       uint32              //最大消息长度
+	newAgent        func(*WSConn) Agent //创建代理函数
+	upgrader        websocket.Upgrader  //HTTP升级器
+	conns           WebsocketConnSet    //连接集合
+	mutexConns      sync.Mutex          //互斥锁
+	wg              sync.WaitGroup      //等待组
+	readTimeout     time.Duration       //读取超时
+	pingInterval    time.Duration       //ping间隔
+
+	// ip limit IP限制
+	maxConnPerIP int            //每个IP的最大连接数
+	filter       *IPFilter      //IP过滤器
+	ipConns      map[string]int //IP->连接数，包括正在握手的连接
+}
+
+//处理HTTP请求，升级为WebSocket连接后运行代理
+func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" { //WebSocket握手只接受GET
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	handler.wg.Add(1)
+	defer handler.wg.Done() //最后执行，关闭服务器时IP的连接数已经减少
+
+	ip := hostIP(r.RemoteAddr)
+	if handler.filter != nil && ip != nil { //在升级之前过滤IP
+		if err := handler.filter.Check(ip); err != nil {
+			http.Error(w, "Forbidden", 403)
+			log.Debug("refuse %v: %v", ip, err)
+			return
+		}
+	}
+	if ip != nil {
+		if !handler.acquireIP(ip.String()) { //同一个IP的连接太多
+			http.Error(w, "Too many connections", 503)
+			log.Debug("too many connections from %v", ip)
+			return
+		}
+		defer handler.releaseIP(ip.String())
+	}
+
+	conn, err := handler.upgrader.Upgrade(w, r, nil) //升级为WebSocket连接
+	if err != nil {
+		log.Debug("upgrade error: %v", err)
+		return
+	}
+	conn.SetReadLimit(int64(handler.maxMsgLen)) //超过最大长度的消息读取时会返回错误
+
+	handler.mutexConns.Lock()
+	if handler.conns == nil { //服务器已关闭
+		handler.mutexConns.Unlock()
+		conn.Close()
+		return
+	}
+	if len(handler.conns) >= handler.maxConnNum { //如果当前连接数超过上限
+		handler.mutexConns.Unlock()
+		conn.Close()
+		log.Debug("too many connections")
+		return
+	}
+	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen) //创建一个WebSocket连接
+	handler.conns[conn] = wsConn                                          //增加连接记录
+	handler.mutexConns.Unlock()
+
+	if handler.readTimeout > 0 {
+		wsConn.setReadTimeout(handler.readTimeout)
+	}
+	if handler.pingInterval > 0 {
+		wsConn.ping(handler.pingInterval)
+	}
+
+	agent := handler.newAgent(wsConn) //调用注册的创建代理函数创建代理
+	agent.Run()                       //ServeHTTP本身就在独立的goroutine中
+
+	// cleanup
+	//清理工作
+	wsConn.Close()
+	handler.mutexConns.Lock()
+	delete(handler.conns, conn)
+	handler.mutexConns.Unlock()
+	agent.OnClose()
+}
+
+//增加IP的连接数，超过maxConnPerIP时返回false
+func (handler *WSHandler) acquireIP(ip string) bool {
+	handler.mutexConns.Lock()
+	defer handler.mutexConns.Unlock()
+	if handler.maxConnPerIP > 0 && handler.ipConns[ip] >= handler.maxConnPerIP {
+		return false
+	}
+	handler.ipConns[ip]++
+	return true
+}
+
+//减少IP的连接数
+func (handler *WSHandler) releaseIP(ip string) {
+	handler.mutexConns.Lock()
+	defer handler.mutexConns.Unlock()
+	if n := handler.ipConns[ip]; n > 1 {
+		handler.ipConns[ip] = n - 1
+	} else {
+		delete(handler.ipConns, ip)
+	}
+}
+
+//启动WebSocket服务器
+func (server *WSServer) Start() {
+	ln, err := net.Listen("tcp", server.Addr) //监听
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+
+	if server.MaxConnNum <= 0 {
+		server.MaxConnNum = 100
+		log.Release("invalid MaxConnNum, reset to %v", server.MaxConnNum)
+	}
+	if server.PendingWriteNum <= 0 {
+		server.PendingWriteNum = 100
+		log.Release("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
+	}
+	if server.MaxMsgLen <= 0 {
+		server.MaxMsgLen = 4096
+		log.Release("invalid MaxMsgLen, reset to %v", server.MaxMsgLen)
+	}
+	if server.HTTPTimeout <= 0 {
+		server.HTTPTimeout = 10 * time.Second
+		log.Release("invalid HTTPTimeout, reset to %v", server.HTTPTimeout)
+	}
+	if server.NewAgent == nil {
+		log.Fatal("NewAgent must not be nil")
+	}
+
+	server.ln = ln
+	server.handler = &WSHandler{
+		maxConnNum:      server.MaxConnNum,
+		pendingWriteNum: server.PendingWriteNum,
+		maxMsgLen:       server.MaxMsgLen,
+		newAgent:        server.NewAgent,
+		readTimeout:     server.ReadTimeout,
+		pingInterval:    server.PingInterval,
+		maxConnPerIP:    server.MaxConnPerIP,
+		filter:          server.Filter,
+		ipConns:         make(map[string]int),
+		conns:           make(WebsocketConnSet),
+		upgrader: websocket.Upgrader{
+			HandshakeTimeout: server.HTTPTimeout,
+			CheckOrigin:      func(_ *http.Request) bool { return true }, //H5客户端跨域访问
+		},
+	}
+
+	if metrics.Enabled() { //统计连接数
+		handler := server.handler
+		metrics.RegisterGaugeFunc("leaf_network_connections", "Number of connections accepted by the server.", func() float64 {
+			handler.mutexConns.Lock()
+			defer handler.mutexConns.Unlock()
+			return float64(len(handler.conns))
+		}, "addr", server.Addr)
+	}
+
+	httpServer := &http.Server{
+		Addr:           server.Addr,
+		Handler:        server.handler,
+		ReadTimeout:    server.HTTPTimeout,
+		WriteTimeout:   server.HTTPTimeout,
+		MaxHeaderBytes: 1024,
+	}
+
+	go httpServer.Serve(ln) //在一个goroutine里运行HTTP服务器
+}
+
+//关闭WebSocket服务器
+func (server *WSServer) Close() {
+	server.ln.Close() //关闭监听器
+
+	server.handler.mutexConns.Lock()
+	conns := server.handler.conns
+	for conn, wsConn := range conns { //关闭所有连接，导致代理读取数据出错退出
+		if server.CloseTimeout > 0 {
+			wsConn.Close() //发送完缓冲区中的数据后再关闭
+		} else {
+			conn.Close()
+		}
+	}
+	server.handler.conns = nil //置空，之后升级的连接直接关闭
+	server.handler.mutexConns.Unlock()
+
+	if server.CloseTimeout > 0 && !waitTimeout(&server.handler.wg, server.CloseTimeout) {
+		log.Release("close timeout, destroy %v connections", len(conns))
+		for conn := range conns { //强制关闭还没有写完的连接
+			conn.Close()
+		}
+	}
+	server.handler.wg.Wait() //等待所有代理退出
+}
@@ -1,8 +1,12 @@
 package recordfile_test
 
 import (
+	"errors"
 	"fmt"
 	"github.com/name5566/leaf/recordfile"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 )
 
 func Example() {
@@ -62,3 +66,44 @@ func Example() {
 	// 6
 	// name5566
 }
+
+func ExampleTable() {
+	type Record struct {
+		ID    int "index"
+		Value int
+	}
+
+	name := filepath.Join(os.TempDir(), "leaf_example_table.txt")
+	defer os.Remove(name)
+	ioutil.WriteFile(name, []byte("id\tvalue\n1\t100\n"), 0644)
+
+	t, err := recordfile.NewTable(Record{}, name, func(rf *recordfile.RecordFile) error {
+		if rf.NumRecord() == 0 {
+			return errors.New("empty table")
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	t.OnReload(func(rf *recordfile.RecordFile) {
+		fmt.Println("reloaded", rf.NumRecord())
+	})
+
+	old := t.RecordFile()
+	ioutil.WriteFile(name, []byte("id\tvalue\n1\t200\n2\t300\n"), 0644)
+	t.Reload()
+	fmt.Println(old.Index(1).(*Record).Value, t.RecordFile().Index(1).(*Record).Value)
+
+	//校验不通过，保留原来的数据
+	ioutil.WriteFile(name, []byte("id\tvalue\n"), 0644)
+	fmt.Println(t.Reload())
+	fmt.Println(t.RecordFile().NumRecord())
+
+	// Output:
+	// reloaded 2
+	// 100 200
+	// empty table
+	// 2
+}
@@ -0,0 +1,146 @@
+package recordfile
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//可热加载的记录文件表
+//每次加载都会读取到一个新的RecordFile，全部解析并校验通过后才原子替换当前的RecordFile
+//已经发布的RecordFile不会再被修改，所以任何goroutine都可以通过RecordFile()安全地读取
+type Table struct {
+	name     string                  //文件名
+	st       interface{}             //记录对应的结构体
+	validate func(*RecordFile) error //额外的校验函数，可以为nil
+	rf       atomic.Value            //当前的记录文件(*RecordFile)
+	mutex    sync.Mutex              //保证加载串行执行
+	modTime  time.Time               //最后一次加载时文件的修改时间
+	cbs      []func(*RecordFile)     //加载成功后的回调
+	closeSig chan bool               //停止检查文件修改的信号
+}
+
+//创建一个记录文件表并完成第一次加载
+//validate在每次加载后、替换前调用，返回错误则放弃本次加载
+func NewTable(st interface{}, name string, validate func(*RecordFile) error) (*Table, error) {
+	t := new(Table)
+	t.name = name
+	t.st = st
+	t.validate = validate
+
+	err := t.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+//文件名
+func (t *Table) Name() string {
+	return t.name
+}
+
+// goroutine safe
+//获取当前的记录文件，同一个处理函数内应只获取一次，以免前后读到不同版本的数据
+func (t *Table) RecordFile() *RecordFile {
+	return t.rf.Load().(*RecordFile)
+}
+
+//注册加载成功后的回调，回调在执行Reload的goroutine中调用
+//需要在模块goroutine中处理的，可以在回调中向模块的ChanRPC发起Go调用
+func (t *Table) OnReload(cb func(*RecordFile)) {
+	t.mutex.Lock()
+	t.cbs = append(t.cbs, cb)
+	t.mutex.Unlock()
+}
+
+// goroutine safe
+//重新读取文件，出错时保留原来的数据
+func (t *Table) Reload() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	fi, err := os.Stat(t.name)
+	if err != nil {
+		return err
+	}
+	t.modTime = fi.ModTime() //出错时也记录，文件再次修改前不重复加载
+
+	rf, err := New(t.st)
+	if err != nil {
+		return err
+	}
+	err = rf.Read(t.name)
+	if err != nil {
+		return err
+	}
+	if t.validate != nil {
+		err = t.validate(rf)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.rf.Store(rf)
+	for _, cb := range t.cbs {
+		cb(rf)
+	}
+
+	return nil
+}
+
+//文件在上次加载后被修改过
+func (t *Table) modified() bool {
+	fi, err := os.Stat(t.name)
+	if err != nil {
+		return false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return !fi.ModTime().Equal(t.modTime)
+}
+
+//每隔d检查一次文件修改时间，有修改则重新加载，加载出错时通过errCb通知(可以为nil)
+func (t *Table) Watch(d time.Duration, errCb func(error)) {
+	t.mutex.Lock()
+	if t.closeSig != nil {
+		t.mutex.Unlock()
+		return
+	}
+	closeSig := make(chan bool)
+	t.closeSig = closeSig
+	t.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-closeSig:
+				return
+			case <-ticker.C:
+				if !t.modified() {
+					continue
+				}
+				err := t.Reload()
+				if err != nil && errCb != nil {
+					errCb(err)
+				}
+			}
+		}
+	}()
+}
+
+//停止检查文件修改
+func (t *Table) Close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closeSig != nil {
+		close(t.closeSig)
+		t.closeSig = nil
+	}
+}
@@ -0,0 +1,62 @@
+package timer_test
+
+import (
+	"github.com/name5566/leaf/timer"
+	"testing"
+	"time"
+)
+
+const benchmarkTimers = 100000
+
+//注册100k个定时器后全部停止
+func benchmarkAfterFunc(b *testing.B, d *timer.Dispatcher) {
+	ts := make([]*timer.Timer, benchmarkTimers)
+	cb := func() {}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range ts {
+			ts[j] = d.AfterFunc(time.Second+time.Duration(j)*time.Millisecond, cb)
+		}
+		for _, t := range ts {
+			t.Stop()
+		}
+	}
+}
+
+func BenchmarkAfterFunc(b *testing.B) {
+	benchmarkAfterFunc(b, timer.NewDispatcher(benchmarkTimers))
+}
+
+func BenchmarkWheelAfterFunc(b *testing.B) {
+	d := timer.NewWheelDispatcher(benchmarkTimers, time.Millisecond)
+	defer d.Close()
+	benchmarkAfterFunc(b, d)
+}
+
+//注册100k个定时器并等待全部到时
+func benchmarkFire(b *testing.B, d *timer.Dispatcher) {
+	cb := func() {}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchmarkTimers; j++ {
+			d.AfterFunc(time.Duration(j%10)*time.Millisecond, cb)
+		}
+		for j := 0; j < benchmarkTimers; j++ {
+			(<-d.ChanTimer).Cb()
+		}
+	}
+}
+
+func BenchmarkFire(b *testing.B) {
+	benchmarkFire(b, timer.NewDispatcher(benchmarkTimers))
+}
+
+func BenchmarkWheelFire(b *testing.B) {
+	d := timer.NewWheelDispatcher(benchmarkTimers, time.Millisecond)
+	defer d.Close()
+	benchmarkFire(b, d)
+}
@@ -27,6 +27,52 @@ func ExampleTimer() {
 	// My name is Leaf
 }
 
+func ExampleDispatcher_RepeatFunc() {
+	d := timer.NewDispatcher(10)
+
+	n := 0
+	var t *timer.Timer
+	t = d.RepeatFunc(time.Millisecond, timer.FixedDelay, func() {
+		n++
+		fmt.Println("tick", n)
+		if n == 3 {
+			t.Stop()
+		}
+	})
+
+	// dispatch
+	for i := 0; i < 3; i++ {
+		(<-d.ChanTimer).Cb()
+	}
+	fmt.Println(t.Remaining())
+
+	// Output:
+	// tick 1
+	// tick 2
+	// tick 3
+	// 0s
+}
+
+func ExampleTimer_Reset() {
+	d := timer.NewDispatcher(10)
+
+	t := d.AfterFunc(time.Hour, func() {
+		fmt.Println("My name is Leaf")
+	})
+	fmt.Println(t.Remaining() > 59*time.Minute)
+
+	t.Reset(time.Millisecond)
+
+	// dispatch
+	(<-d.ChanTimer).Cb()
+	fmt.Println(t.Remaining())
+
+	// Output:
+	// true
+	// My name is Leaf
+	// 0s
+}
+
 func ExampleCronExpr() {
 	cronExpr, err := timer.NewCronExpr("0 * * * *")
 	if err != nil {
@@ -59,3 +105,25 @@ func ExampleCron() {
 	// Output:
 	// My name is Leaf
 }
+
+func ExampleNewWheelDispatcher() {
+	d := timer.NewWheelDispatcher(10, time.Millisecond)
+	defer d.Close()
+
+	// timer 1
+	d.AfterFunc(2*time.Millisecond, func() {
+		fmt.Println("My name is Leaf")
+	})
+
+	// timer 2
+	t := d.AfterFunc(time.Millisecond, func() {
+		fmt.Println("will not print")
+	})
+	t.Stop()
+
+	// dispatch
+	(<-d.ChanTimer).Cb()
+
+	// Output:
+	// My name is Leaf
+}
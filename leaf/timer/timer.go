@@ -12,6 +12,7 @@ import (
 //分发器类型定义
 type Dispatcher struct {
 	ChanTimer chan *Timer //管道，用于传输定时器
+	wheel     *wheel      //时间轮，为nil时每个定时器使用一个time.Timer
 }
 
 //创建分发器
@@ -21,23 +22,159 @@ func NewDispatcher(l int) *Dispatcher {
 	return disp                           //返回分发器
 }
 
+//创建使用分层时间轮的分发器，定时器的精度为tick
+//大量定时器时比每个定时器一个time.Timer开销小，用完需要调用Close
+func NewWheelDispatcher(l int, tick time.Duration) *Dispatcher {
+	if tick <= 0 {
+		panic("invalid tick")
+	}
+
+	disp := NewDispatcher(l)
+	disp.wheel = newWheel(tick, disp.ChanTimer)
+	return disp
+}
+
+//关闭分发器，停止时间轮，不使用时间轮时什么也不做
+func (disp *Dispatcher) Close() {
+	if disp.wheel != nil {
+		disp.wheel.close()
+	}
+}
+
+//重复定时器的模式
+type RepeatMode int
+
+const (
+	FixedRate  RepeatMode = iota //固定频率，按照上一次预定的时间计算下一次，回调耗时不影响频率，落后太多时跳过错过的次数
+	FixedDelay                   //固定延迟，回调执行完后再等待一个间隔
+)
+
 // Timer
 //定时器类型定义
+//除了到时的投递外，定时器的所有操作都在分发器所在的goroutine中执行
 type Timer struct {
-	t  *time.Timer //底层定时器
-	cb func()      //回调函数
+	disp   *Dispatcher   //所属的分发器
+	t      *time.Timer   //底层定时器(不使用时间轮时)
+	cb     func()        //回调函数
+	fn     func()        //计划任务的用户回调，为nil时就是cb
+	period time.Duration //重复间隔，为0则只执行一次
+	mode   RepeatMode    //重复模式
+	when   time.Time     //预定的到时时间
+	armed  bool          //已经注册且还没有执行回调
+	stop   bool          //调用了Stop
+	stale  int           //已经投递到管道但被取消的次数，对应的Cb调用会被忽略
+
+	// wheel
+	expire     uint64     //到时的tick
+	list       *timerList //所在的时间轮槽
+	prev, next *Timer     //链表指针
+}
+
+//注册定时器，d时间后投递到分发器的管道
+func (t *Timer) arm(d time.Duration) {
+	t.when = time.Now().Add(d)
+	t.armed = true
+	t.stop = false
+
+	if w := t.disp.wheel; w != nil {
+		w.addTimer(t, d)
+		return
+	}
+	if t.t == nil {
+		t.t = time.AfterFunc(d, func() { //注意，这里的func是在定时器自己的goroutine中执行的
+			t.disp.ChanTimer <- t //定时器到时，将定时器发送到管道中
+		})
+	} else {
+		t.t.Reset(d)
+	}
+}
+
+//取消注册，如果已经投递(或正在投递)到管道，则忽略对应的那一次Cb
+func (t *Timer) disarm() {
+	if !t.armed {
+		return
+	}
+	t.armed = false
+
+	var stopped bool
+	if w := t.disp.wheel; w != nil {
+		stopped = w.removeTimer(t) //从时间轮中删除
+	} else {
+		stopped = t.t.Stop() //停止底层定时器
+	}
+	if !stopped {
+		t.stale++
+	}
 }
 
 //停止定时器
 func (t *Timer) Stop() {
-	t.t.Stop() //停止底层定时器
-	t.cb = nil //置空回调函数
+	t.disarm()
+	t.stop = true
+}
+
+//重新设置定时器在d时间后到时，不管之前是否已经到时或者停止，重复定时器的间隔不变
+func (t *Timer) Reset(d time.Duration) {
+	t.disarm()
+	t.arm(d)
+}
+
+//修改定时器的重复间隔，从现在开始重新计时，一次性的定时器会变成重复定时器
+func (t *Timer) Reschedule(period time.Duration) {
+	if period <= 0 {
+		panic("invalid period")
+	}
+
+	t.disarm()
+	t.period = period
+	t.arm(period)
+}
+
+//距离到时还剩多少时间，已经到时或者停止的返回0
+func (t *Timer) Remaining() time.Duration {
+	if !t.armed {
+		return 0
+	}
+	d := t.when.Sub(time.Now())
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+//用户注册的回调函数，用于统计和日志，计划任务的定时器返回CronFunc的回调
+func (t *Timer) Func() func() {
+	if t.fn != nil {
+		return t.fn
+	}
+	return t.cb
 }
 
 //调用定时器的回调函数
 func (t *Timer) Cb() {
+	if t.stale > 0 { //已经被取消的投递
+		t.stale--
+		return
+	}
+	if !t.armed {
+		return
+	}
+	t.armed = false
+
+	if t.period > 0 && t.mode == FixedRate { //先注册下一次，回调的耗时不影响频率
+		now := time.Now()
+		next := t.when.Add(t.period)
+		if next.Before(now) { //落后了，跳过错过的次数
+			next = next.Add((now.Sub(next)/t.period + 1) * t.period)
+		}
+		t.arm(next.Sub(now))
+	}
+
 	defer func() { //延迟执行
-		t.cb = nil                    //置空回调
+		//回调执行完(包括出错)后注册下一次，回调中调用了Stop或者Reset则不注册
+		if t.period > 0 && t.mode == FixedDelay && !t.stop && !t.armed {
+			t.arm(t.period)
+		}
 		if r := recover(); r != nil { //捕获异常
 			if conf.LenStackBuf > 0 { //堆栈buf长度大于0
 				//打印堆栈信息
@@ -57,14 +194,28 @@ func (t *Timer) Cb() {
 
 //注册定时器
 func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
-	t := new(Timer)                  //创建定时器
-	t.cb = cb                        //设置回调函数
-	t.t = time.AfterFunc(d, func() { //注意，这里的func是在定时器自己的goroutine中执行的
-		disp.ChanTimer <- t //定时器到时，将定时器发送到管道中
-	})
+	t := new(Timer) //创建定时器
+	t.disp = disp
+	t.cb = cb //设置回调函数
+	t.arm(d)
 	return t //返回自定义的定时器
 }
 
+//注册重复定时器，每隔d执行一次cb，直到Stop
+func (disp *Dispatcher) RepeatFunc(d time.Duration, mode RepeatMode, cb func()) *Timer {
+	if d <= 0 {
+		panic("invalid period")
+	}
+
+	t := new(Timer)
+	t.disp = disp
+	t.cb = cb
+	t.period = d
+	t.mode = mode
+	t.arm(d)
+	return t
+}
+
 // Cron
 //计划任务类型定义
 type Cron struct {
@@ -103,8 +254,10 @@ func (disp *Dispatcher) CronFunc(expr string, _cb func()) (*Cron, error) {
 			return //直接返回，不注册后续的计划任务，会再执行一次用户回调
 		}
 		cron.t = disp.AfterFunc(nextTime.Sub(now), cb) //计算时间差值，注册定时器
+		cron.t.fn = _cb
 	}
 
 	cron.t = disp.AfterFunc(nextTime.Sub(now), cb) //第一次计划任务
+	cron.t.fn = _cb
 	return cron, nil
 }
@@ -0,0 +1,220 @@
+package timer
+
+import (
+	"sync"
+	"time"
+)
+
+//分层时间轮，参考Linux内核的实现
+//第一层256个槽，每个槽一个tick，其余四层各64个槽，每个槽是上一层的一整圈
+//定时器从高层往低层逐层下移(cascade)，最终在第一层到时
+const (
+	wheelNearBits  = 8
+	wheelLevelBits = 6
+	wheelNear      = 1 << wheelNearBits
+	wheelLevel     = 1 << wheelLevelBits
+	wheelNearMask  = wheelNear - 1
+	wheelLevelMask = wheelLevel - 1
+	wheelNumLevel  = 4
+	wheelMaxTicks  = 1<<(wheelNearBits+wheelLevelBits*wheelNumLevel) - 1 //最大的间隔，超过的分多次下移
+)
+
+//定时器链表，双向链表，删除为O(1)
+type timerList struct {
+	head Timer //哨兵
+}
+
+func (l *timerList) init() {
+	l.head.next = &l.head
+	l.head.prev = &l.head
+}
+
+func (l *timerList) push(t *Timer) {
+	t.list = l
+	t.prev = l.head.prev
+	t.next = &l.head
+	l.head.prev.next = t
+	l.head.prev = t
+}
+
+func (l *timerList) remove(t *Timer) {
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev = nil
+	t.next = nil
+	t.list = nil
+}
+
+//取出所有定时器并清空链表
+func (l *timerList) take() *Timer {
+	if l.head.next == &l.head {
+		return nil
+	}
+	first := l.head.next
+	l.head.prev.next = nil
+	l.init()
+	return first
+}
+
+//时间轮类型定义
+type wheel struct {
+	sync.Mutex                                      //保护下面的所有字段，AfterFunc和Stop在模块goroutine中调用
+	tick       time.Duration                        //精度
+	start      time.Time                            //开始时间
+	jiffies    uint64                               //下一个要处理的tick
+	near       [wheelNear]timerList                 //第一层
+	levels     [wheelNumLevel][wheelLevel]timerList //其余各层
+	chanTimer  chan *Timer                          //到时的定时器发送到此管道
+	closeSig   chan bool                            //关闭信号
+	wg         sync.WaitGroup                       //等待组
+}
+
+//创建并运行时间轮
+func newWheel(tick time.Duration, chanTimer chan *Timer) *wheel {
+	w := new(wheel)
+	w.tick = tick
+	w.start = time.Now()
+	w.chanTimer = chanTimer
+	w.closeSig = make(chan bool)
+	for i := range w.near {
+		w.near[i].init()
+	}
+	for i := range w.levels {
+		for j := range w.levels[i] {
+			w.levels[i][j].init()
+		}
+	}
+
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+//把d换算成tick数，向上取整，保证定时器不会提前到时
+func (w *wheel) ticks(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	return uint64((d + w.tick - 1) / w.tick)
+}
+
+//加入定时器，调用前需要加锁
+func (w *wheel) add(t *Timer) {
+	expire := t.expire
+	if expire < w.jiffies { //已经过期的放到下一个处理的槽
+		expire = w.jiffies
+	}
+
+	idx := expire - w.jiffies
+	if idx > wheelMaxTicks { //太远的先放在最高层，下移时重新计算
+		idx = wheelMaxTicks
+		expire = w.jiffies + idx
+	}
+
+	if idx < wheelNear {
+		w.near[expire&wheelNearMask].push(t)
+		return
+	}
+	for i := 0; i < wheelNumLevel; i++ {
+		shift := uint(wheelNearBits + wheelLevelBits*(i+1))
+		if idx < 1<<shift || i == wheelNumLevel-1 {
+			w.levels[i][(expire>>(shift-wheelLevelBits))&wheelLevelMask].push(t)
+			return
+		}
+	}
+}
+
+//注册定时器
+func (w *wheel) addTimer(t *Timer, d time.Duration) {
+	w.Lock()
+	t.expire = w.jiffies + w.ticks(d)
+	w.add(t)
+	w.Unlock()
+}
+
+//删除定时器，定时器已经到时(不在时间轮中)返回false
+func (w *wheel) removeTimer(t *Timer) bool {
+	w.Lock()
+	defer w.Unlock()
+
+	if t.list == nil {
+		return false
+	}
+	t.list.remove(t)
+	return true
+}
+
+//把第level层的一个槽中的定时器重新加入到时间轮(下移)，返回槽索引
+func (w *wheel) cascade(level int) uint64 {
+	shift := uint(wheelNearBits + wheelLevelBits*level)
+	index := (w.jiffies >> shift) & wheelLevelMask
+	for t := w.levels[level][index].take(); t != nil; {
+		next := t.next
+		t.prev, t.next, t.list = nil, nil, nil
+		w.add(t)
+		t = next
+	}
+	return index
+}
+
+//处理一个tick，返回到时的定时器
+func (w *wheel) advance(expired []*Timer) []*Timer {
+	index := w.jiffies & wheelNearMask
+	if index == 0 { //第一层转完一圈，依次下移高层的定时器
+		for level := 0; level < wheelNumLevel; level++ {
+			if w.cascade(level) != 0 {
+				break
+			}
+		}
+	}
+	w.jiffies++
+
+	for t := w.near[index].take(); t != nil; {
+		next := t.next
+		t.prev, t.next, t.list = nil, nil, nil
+		expired = append(expired, t)
+		t = next
+	}
+	return expired
+}
+
+//时间轮goroutine，按照实际流逝的时间推进，不会因为ticker丢tick而变慢
+func (w *wheel) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	var expired []*Timer
+	for {
+		select {
+		case <-w.closeSig:
+			return
+		case now := <-ticker.C:
+			target := uint64(now.Sub(w.start) / w.tick)
+
+			w.Lock()
+			for w.jiffies < target {
+				expired = w.advance(expired)
+			}
+			w.Unlock()
+
+			//解锁后再发送，避免模块goroutine注册定时器时死锁
+			for i, t := range expired {
+				select {
+				case w.chanTimer <- t:
+				case <-w.closeSig:
+					return
+				}
+				expired[i] = nil
+			}
+			expired = expired[:0]
+		}
+	}
+}
+
+//停止时间轮，未到时的定时器不会再被发送
+func (w *wheel) close() {
+	close(w.closeSig)
+	w.wg.Wait()
+}
@@ -10,7 +10,9 @@ func NewSkeleton() *module.Skeleton {
 	skeleton := &module.Skeleton{
 		GoLen:              conf.GoLen,
 		TimerDispatcherLen: conf.TimerDispatcherLen,
+		AsynCallLen:        conf.AsynCallLen,
 		ChanRPCServer:      chanrpc.NewServer(conf.ChanRPCLen),
+		SlowThreshold:      conf.SlowThreshold,
 	}
 	skeleton.Init()
 	return skeleton
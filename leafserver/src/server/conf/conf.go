@@ -1,16 +1,30 @@
 package conf
 
+import (
+	"time"
+)
+
 var (
 	// gate conf
-	Encoding               = "json" // 编码方式定义，"json" or "protobuf"
-	PendingWriteNum        = 2000
-	LenMsgLen              = 2
-	MinMsgLen       uint32 = 2
-	MaxMsgLen       uint32 = 4096
-	LittleEndian           = false
+	Encoding                 = "json" // 编码方式定义，"json" or "protobuf"
+	PendingWriteNum          = 2000
+	LenMsgLen                = 2
+	MinMsgLen         uint32 = 2
+	MaxMsgLen         uint32 = 4096
+	LittleEndian             = false
+	HTTPTimeout              = 10 * time.Second
+	CloseAgentTimeout        = 10 * time.Second
+	CloseTimeout             = 5 * time.Second
+
+	// module conf
+	ModuleCloseTimeout = 30 * time.Second
 
 	// skeleton conf
 	GoLen              = 10000
 	TimerDispatcherLen = 10000
+	AsynCallLen        = 10000
 	ChanRPCLen         = 10000
+
+	// stat conf
+	SlowThreshold = 100 * time.Millisecond
 )
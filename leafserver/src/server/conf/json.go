@@ -9,7 +9,9 @@ import (
 var Server struct {
 	LogLevel   string
 	LogPath    string
+	LogFormat  string
 	Addr       string
+	WSAddr     string
 	MaxConnNum int
 } //定义一个Server结构变量用来存储服务器一些配置
 
@@ -14,14 +14,18 @@ type Module struct {
 
 func (m *Module) OnInit() {
 	m.TCPGate = &gate.TCPGate{
-		Addr:            conf.Server.Addr,
-		MaxConnNum:      conf.Server.MaxConnNum,
-		PendingWriteNum: conf.PendingWriteNum,
-		LenMsgLen:       conf.LenMsgLen,
-		MinMsgLen:       conf.MinMsgLen,
-		MaxMsgLen:       conf.MaxMsgLen,
-		LittleEndian:    conf.LittleEndian,
-		AgentChanRPC:    game.ChanRPC,
+		Addr:              conf.Server.Addr,
+		WSAddr:            conf.Server.WSAddr,
+		HTTPTimeout:       conf.HTTPTimeout,
+		MaxConnNum:        conf.Server.MaxConnNum,
+		PendingWriteNum:   conf.PendingWriteNum,
+		LenMsgLen:         conf.LenMsgLen,
+		MinMsgLen:         conf.MinMsgLen,
+		MaxMsgLen:         conf.MaxMsgLen,
+		LittleEndian:      conf.LittleEndian,
+		AgentChanRPC:      game.ChanRPC,
+		CloseAgentTimeout: conf.CloseAgentTimeout,
+		CloseTimeout:      conf.CloseTimeout,
 	}
 
 	switch conf.Encoding {
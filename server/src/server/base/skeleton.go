@@ -11,7 +11,9 @@ func NewSkeleton() *module.Skeleton {
 	skeleton := &module.Skeleton{ //创建骨架
 		GoLen:              conf.GoLen,
 		TimerDispatcherLen: conf.TimerDispatcherLen,
+		AsynCallLen:        conf.AsynCallLen,
 		ChanRPCServer:      chanrpc.NewServer(conf.ChanRPCLen),
+		SlowThreshold:      conf.SlowThreshold,
 	}
 	skeleton.Init() //初始化骨架
 	return skeleton //返回骨架
@@ -1,16 +1,65 @@
 package conf
 
+import (
+	"github.com/name5566/leaf/network"
+	"time"
+)
+
 var (
 	// gate conf 网关配置
-	Encoding               = "json" // "json" or "protobuf"
-	PendingWriteNum        = 2000
-	LenMsgLen              = 2
-	MinMsgLen       uint32 = 2
-	MaxMsgLen       uint32 = 4096
-	LittleEndian           = false
+	Encoding                 = "json" // "json" or "protobuf"
+	PendingWriteNum          = 2000
+	LenMsgLen                = 2
+	MinMsgLen         uint32 = 2
+	MaxMsgLen         uint32 = 4096
+	LittleEndian             = false
+	HTTPTimeout              = 10 * time.Second
+	CloseAgentTimeout        = 10 * time.Second
+	CloseTimeout             = 5 * time.Second
+
+	// session conf 会话配置
+	ResumeTimeout = 30 * time.Second //断线后保留会话的时间，为0则不支持断线重连
+	ResumeMsgNum  = 1000             //断线期间最多缓存的消息数
+	Reliable      = false            //可靠传输，消息前加上序号和确认号，需要客户端支持
+	ReplayMsgNum  = 1000             //等待客户端确认的消息最多缓存的条数
+
+	// rate limit conf 限流配置
+	MsgRateLimit  = 0.0     //每个连接每秒的消息数，超过时断开连接，为0不限制，需要时再开启，例如50
+	MsgBurst      = 100     //每个连接允许突发的消息数
+	ByteRateLimit = 65536.0 //每个连接每秒的字节数，超过时暂停读取，为0不限制
+	AuthRateLimit = 1.0     //每个连接每秒的登录消息数，超过时丢弃
+
+	// ip filter conf IP过滤配置，封禁可以使用控制台命令ip
+	MaxConnPerIP = 20         //每个IP的最大连接数，为0不限
+	AllowIPs     = []string{} //白名单，CIDR或者IP，为空允许所有不在黑名单中的IP
+	DenyIPs      = []string{} //黑名单，CIDR或者IP
+
+	// heartbeat conf 心跳配置，默认关闭
+	//开启前客户端必须能接受并原样回复len为0的心跳帧，否则会因为消息太短而断开连接，例如ReadTimeout为60s，PingInterval为20s
+	ReadTimeout  time.Duration = 0 //多久没有收到客户端的消息就断开连接，为0不检查
+	PingInterval time.Duration = 0 //服务器发送心跳帧的间隔，为0不发送
+
+	// backpressure conf 发送缓冲区满时的处理，S2C_Close使用关键优先级发送，不会被丢弃
+	OverflowPolicy = network.OverflowDisconnect //断开较慢的客户端，OverflowDropOldest会静默丢弃游戏消息，OverflowBlock/OverflowSpill见network包
+
+	// kcp conf KCP配置，使用快速模式，适合战斗等对延迟敏感的消息
+	KCPNoDelay      = true                  //使用更小的最小RTO
+	KCPInterval     = 10 * time.Millisecond //刷新间隔
+	KCPResend       = 2                     //被跳过2次确认后快速重传
+	KCPNoCongestion = true                  //关闭拥塞控制
+
+	// module conf 模块配置
+	ModuleCloseTimeout = 30 * time.Second //模块关闭超时
+
+	// gamedata conf 游戏数据配置
+	GameDataWatchInterval = 10 * time.Second //检查游戏数据文件修改的间隔，为0则只能通过控制台命令reload重新加载
 
 	// skeleton conf 骨架配置
 	GoLen              = 10000 //Go管道长度
 	TimerDispatcherLen = 10000 //定时器分发器管道长度
+	AsynCallLen        = 10000 //异步调用返回管道长度
 	ChanRPCLen         = 10000 //RPC服务器管道长度
+
+	// stat conf 统计配置
+	SlowThreshold = 100 * time.Millisecond //处理单项超过该时间时记录日志，为0则不统计处理耗时
 )
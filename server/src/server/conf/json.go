@@ -9,7 +9,12 @@ import (
 var Server struct {
 	LogLevel     string //日志级别
 	LogPath      string //日志路径
+	LogFormat    string //日志格式
 	Addr         string //游戏服务器地址
+	WSAddr       string //WebSocket地址，为空则不开启
+	KCPAddr      string //KCP(可靠UDP)地址，为空则不开启
+	CertFile     string //TLS证书文件，和KeyFile都设置时游戏服务器地址只接受TLS连接
+	KeyFile      string //TLS私钥文件
 	MaxConnNum   int    //最大连接数
 	DBUrl        string //数据库地址
 	DBMaxConnNum int    //数据库最大连接数
@@ -7,4 +7,6 @@ import (
 var (
 	Module  = new(internal.Module)
 	ChanRPC = internal.ChanRPC
+
+	UserLogin = internal.UserLogin
 )
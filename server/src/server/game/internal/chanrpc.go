@@ -1,8 +1,10 @@
 package internal
 
 import (
+	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/gate"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
 	"server/msg"
 )
 
@@ -11,22 +13,24 @@ type AgentInfo struct {
 	userID int
 }
 
+//类型化的RPC定义，参数类型在编译期检查
+var (
+	NewAgent   = chanrpc.Proc1[gate.Agent]{ID: "NewAgent"}
+	CloseAgent = chanrpc.Proc2[gate.Agent, network.CloseReason]{ID: "CloseAgent"}
+	UserLogin  = chanrpc.Proc2[gate.Agent, string]{ID: "UserLogin"}
+)
+
 func init() {
-	skeleton.RegisterChanRPC("NewAgent", rpcNewAgent)
-	skeleton.RegisterChanRPC("CloseAgent", rpcCloseAgent)
-	skeleton.RegisterChanRPC("UserLogin", rpcUserLogin)
+	NewAgent.Register(ChanRPC, rpcNewAgent)
+	CloseAgent.Register(ChanRPC, rpcCloseAgent)
+	UserLogin.Register(ChanRPC, rpcUserLogin)
 }
 
-func rpcNewAgent(args []interface{}) {
-	a := args[0].(gate.Agent)
-
+func rpcNewAgent(a gate.Agent) {
 	a.SetUserData(new(AgentInfo))
 }
 
-func rpcUserLogin(args []interface{}) {
-	a := args[0].(gate.Agent)
-	accID := args[1].(string)
-
+func rpcUserLogin(a gate.Agent, accID string) {
 	// network closed
 	if a.UserData() == nil {
 		return
@@ -36,8 +40,8 @@ func rpcUserLogin(args []interface{}) {
 	oldUser := accIDUsers[accID]
 	if oldUser != nil {
 		m := &msg.S2C_Close{Err: msg.S2C_Close_LoginRepeated}
-		a.WriteMsg(m)
-		oldUser.WriteMsg(m)
+		a.WriteMsgPriority(m, network.PriorityCritical)
+		oldUser.WriteMsgPriority(m, network.PriorityCritical)
 		a.Close()
 		oldUser.Close()
 		log.Debug("acc %v login repeated", accID)
@@ -57,9 +61,10 @@ func rpcUserLogin(args []interface{}) {
 	newUser.login(accID)
 }
 
-func rpcCloseAgent(args []interface{}) {
-	a := args[0].(gate.Agent)
-
+func rpcCloseAgent(a gate.Agent, reason network.CloseReason) {
+	if reason == network.CloseResumed { //连接重连到了原来的会话，不是下线
+		return
+	}
 	accID := a.UserData().(*AgentInfo).accID
 	a.SetUserData(nil)
 
@@ -68,7 +73,7 @@ func rpcCloseAgent(args []interface{}) {
 		return
 	}
 
-	log.Debug("acc %v logout", accID)
+	log.Debug("acc %v logout: %v", accID, reason)
 
 	// logout
 	if user.state == userLogin {
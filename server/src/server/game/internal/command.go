@@ -0,0 +1,24 @@
+package internal
+
+import (
+	"server/gamedata"
+)
+
+//注册命令
+func init() {
+	skeleton.RegisterCommand("reload", "reload game data, usage: reload [table]", commandReload)
+}
+
+//重新加载游戏数据
+func commandReload(args []interface{}) interface{} {
+	name := ""
+	if len(args) > 0 {
+		name = args[0].(string)
+	}
+
+	err := gamedata.Reload(name)
+	if err != nil {
+		return err.Error()
+	}
+	return "done"
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/name5566/leaf/gate"
 	"github.com/name5566/leaf/go"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
 	"github.com/name5566/leaf/timer"
 	"github.com/name5566/leaf/util"
 	"gopkg.in/mgo.v2"
@@ -45,7 +46,7 @@ func (user *User) login(accID string) {
 			if err != mgo.ErrNotFound {
 				log.Error("load acc %v data error: %v", accID, err)
 				userData = nil
-				user.WriteMsg(&msg.S2C_Close{Err: msg.S2C_Close_InnerError})
+				user.WriteMsgPriority(&msg.S2C_Close{Err: msg.S2C_Close_InnerError}, network.PriorityCritical)
 				user.Close()
 				return
 			}
@@ -55,7 +56,7 @@ func (user *User) login(accID string) {
 			if err != nil {
 				log.Error("init acc %v data error: %v", accID, err)
 				userData = nil
-				user.WriteMsg(&msg.S2C_Close{Err: msg.S2C_Close_InnerError})
+				user.WriteMsgPriority(&msg.S2C_Close{Err: msg.S2C_Close_InnerError}, network.PriorityCritical)
 				user.Close()
 				return
 			}
@@ -123,7 +124,7 @@ func (user *User) autoSaveDB() {
 				log.Error("save user %v data error: %v", userID, err)
 			}
 		}, func() {
-			user.autoSaveDB()
+			user.autoSaveDB() //保存完成后再等待下一次，避免同一个用户的保存重叠
 		})
 	})
 }
@@ -1,29 +1,60 @@
 package gamedata
 
-var (
+import (
+	"fmt"
+	"github.com/name5566/leaf/recordfile"
+	"sync/atomic"
+)
+
+//全局配置，每次加载生成一份新的，发布后不再修改
+type Global struct {
 	AccIDMin int
 	AccIDMax int
-)
+}
 
-func setGlobalConf(id int, value int) {
-	switch id {
-	case 1:
-		AccIDMin = value
-	case 2:
-		AccIDMax = value
-	}
+var global atomic.Value
+
+// goroutine safe
+//获取当前的全局配置，同一个处理函数内应只获取一次
+func GetGlobal() *Global {
+	return global.Load().(*Global)
 }
 
-func init() {
-	type GlobalConf struct {
-		ID    int
-		Value int
-		_     string
-	}
+type GlobalConf struct {
+	ID    int
+	Value int
+	_     string
+}
 
-	rf := readRf(GlobalConf{})
+func newGlobal(rf *recordfile.RecordFile) (*Global, error) {
+	g := new(Global)
 	for i := 0; i < rf.NumRecord(); i++ {
 		r := rf.Record(i).(*GlobalConf)
-		setGlobalConf(r.ID, r.Value)
+		switch r.ID {
+		case 1:
+			g.AccIDMin = r.Value
+		case 2:
+			g.AccIDMax = r.Value
+		}
 	}
+	if g.AccIDMin > g.AccIDMax {
+		return nil, fmt.Errorf("invalid AccID range: %v-%v", g.AccIDMin, g.AccIDMax)
+	}
+
+	return g, nil
+}
+
+func init() {
+	validate := func(rf *recordfile.RecordFile) error {
+		_, err := newGlobal(rf)
+		return err
+	}
+	publish := func(rf *recordfile.RecordFile) {
+		g, _ := newGlobal(rf) //已经校验过
+		global.Store(g)
+	}
+
+	t := readTable(GlobalConf{}, validate)
+	publish(t.RecordFile())
+	t.OnReload(publish)
 }
@@ -1,21 +1,58 @@
 package gamedata
 
 import (
+	"errors"
+	"fmt"
 	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/recordfile"
 	"reflect"
+	"server/conf"
+	"sort"
 )
 
-func readRf(st interface{}) *recordfile.RecordFile {
-	rf, err := recordfile.New(st)
-	if err != nil {
-		log.Fatal("%v", err)
-	}
+//所有可热加载的表，表名(结构体名)->表
+var tables = make(map[string]*recordfile.Table)
+
+//读取可热加载的表，validate在替换数据前调用，可以为nil
+func readTable(st interface{}, validate func(*recordfile.RecordFile) error) *recordfile.Table {
 	fn := reflect.TypeOf(st).Name() + ".txt"
-	err = rf.Read("gamedata/" + fn)
+	t, err := recordfile.NewTable(st, "gamedata/"+fn, validate)
 	if err != nil {
 		log.Fatal("%v: %v", fn, err)
 	}
+	if conf.GameDataWatchInterval > 0 { //文件修改后自动重新加载
+		t.Watch(conf.GameDataWatchInterval, func(err error) {
+			log.Error("reload %v error: %v", fn, err)
+		})
+	}
+	tables[reflect.TypeOf(st).Name()] = t
+
+	return t
+}
 
-	return rf
+// goroutine safe
+//重新加载表，name为空则重新加载所有的表
+//任何一个表出错都不会影响其原有数据，也不会影响其它表的加载，返回所有表的错误
+func Reload(name string) error {
+	if name != "" {
+		t, ok := tables[name]
+		if !ok {
+			return fmt.Errorf("table %v not found", name)
+		}
+		return t.Reload()
+	}
+
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names) //按表名的顺序加载，错误的顺序固定
+
+	var errs []error
+	for _, name := range names {
+		if err := tables[name].Reload(); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %v", name, err))
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -3,6 +3,8 @@ package internal
 import (
 	"github.com/name5566/leaf/gate"
 	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/network"
+	"reflect"
 	"server/conf"
 	"server/game"
 	"server/msg"
@@ -16,16 +18,58 @@ type Module struct {
 //模块初始化
 func (m *Module) OnInit() {
 	m.TCPGate = &gate.TCPGate{
-		Addr:            conf.Server.Addr,
-		MaxConnNum:      conf.Server.MaxConnNum,
-		PendingWriteNum: conf.PendingWriteNum,
-		LenMsgLen:       conf.LenMsgLen,
-		MinMsgLen:       conf.MinMsgLen,
-		MaxMsgLen:       conf.MaxMsgLen,
-		LittleEndian:    conf.LittleEndian,
-		AgentChanRPC:    game.ChanRPC,
+		Addr:              conf.Server.Addr,
+		WSAddr:            conf.Server.WSAddr,
+		KCPAddr:           conf.Server.KCPAddr,
+		ReadTimeout:       conf.ReadTimeout,
+		PingInterval:      conf.PingInterval,
+		OverflowPolicy:    conf.OverflowPolicy,
+		HTTPTimeout:       conf.HTTPTimeout,
+		MaxConnNum:        conf.Server.MaxConnNum,
+		PendingWriteNum:   conf.PendingWriteNum,
+		LenMsgLen:         conf.LenMsgLen,
+		MinMsgLen:         conf.MinMsgLen,
+		MaxMsgLen:         conf.MaxMsgLen,
+		LittleEndian:      conf.LittleEndian,
+		AgentChanRPC:      game.ChanRPC,
+		CloseAgentTimeout: conf.CloseAgentTimeout,
+		MaxConnPerIP:      conf.MaxConnPerIP,
+		IPFilter:          network.DefaultIPFilter,
+		CloseTimeout:      conf.CloseTimeout,
+		CloseMsg:          &msg.S2C_Close{Err: msg.S2C_Close_ServerShutdown},
+		ResumeTimeout:     conf.ResumeTimeout,
+		ResumeMsgNum:      conf.ResumeMsgNum,
+		Reliable:          conf.Reliable,
+		ReplayMsgNum:      conf.ReplayMsgNum,
+		MsgLimit:          gate.RateLimit{Rate: conf.MsgRateLimit, Burst: conf.MsgBurst, Action: gate.LimitClose},
+		ByteLimit:         gate.RateLimit{Rate: conf.ByteRateLimit, Action: gate.LimitDelay},
+		MsgTypeLimits: map[reflect.Type]gate.RateLimit{
+			reflect.TypeOf(&msg.C2S_Auth{}): {Rate: conf.AuthRateLimit, Burst: 3, Action: gate.LimitDrop},
+		},
+		KCPConfig: network.KCPConfig{
+			NoDelay:      conf.KCPNoDelay,
+			Interval:     conf.KCPInterval,
+			Resend:       conf.KCPResend,
+			NoCongestion: conf.KCPNoCongestion,
+		},
 	} //创建TCP网关
 
+	//设置了证书时使用TLS
+	if conf.Server.CertFile != "" && conf.Server.KeyFile != "" {
+		m.TCPGate.TLS = &network.TLSConfig{
+			CertFile: conf.Server.CertFile,
+			KeyFile:  conf.Server.KeyFile,
+		}
+	}
+
+	//设置IP过滤，和控制台命令ip共用network.DefaultIPFilter
+	if err := network.DefaultIPFilter.SetAllow(conf.AllowIPs); err != nil {
+		log.Fatal("invalid AllowIPs: %v", err)
+	}
+	if err := network.DefaultIPFilter.SetDeny(conf.DenyIPs); err != nil {
+		log.Fatal("invalid DenyIPs: %v", err)
+	}
+
 	//根据Encoding配置设置消息处理器
 	switch conf.Encoding {
 	case "json": //使用JSON处理消息
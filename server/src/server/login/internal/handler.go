@@ -23,13 +23,15 @@ func handleAuth(args []interface{}) {
 	m := args[0].(*msg.C2S_Auth)
 	a := args[1].(gate.Agent)
 
-	if len(m.AccID) < gamedata.AccIDMin || len(m.AccID) > gamedata.AccIDMax {
+	g := gamedata.GetGlobal()
+	if len(m.AccID) < g.AccIDMin || len(m.AccID) > g.AccIDMax {
 		a.WriteMsg(&msg.S2C_Auth{Err: msg.S2C_Auth_AccIDInvalid})
 		return
 	}
 
 	// login
-	game.ChanRPC.Go("UserLogin", a, m.AccID)
+	game.UserLogin.Go(game.ChanRPC, a, m.AccID)
 
-	a.WriteMsg(&msg.S2C_Auth{Err: msg.S2C_Auth_OK})
+	token := a.NewSession() //断线重连的令牌
+	a.WriteMsg(&msg.S2C_Auth{Err: msg.S2C_Auth_OK, Token: token})
 }
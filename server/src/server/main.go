@@ -12,6 +12,8 @@ import (
 func main() {
 	lconf.LogLevel = conf.Server.LogLevel //设置日志级别 lconf为leaf框架conf包的别名
 	lconf.LogPath = conf.Server.LogPath   //设置日志路径
+	lconf.LogFormat = conf.Server.LogFormat
+	lconf.ModuleCloseTimeout = conf.ModuleCloseTimeout
 
 	leaf.Run( //游戏服务器启动，进行模块的注册
 		game.Module,
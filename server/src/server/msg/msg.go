@@ -16,12 +16,15 @@ func init() {
 	JSONProcessor.Register(&S2C_Close{})
 	JSONProcessor.Register(&C2S_Auth{})
 	JSONProcessor.Register(&S2C_Auth{})
+	JSONProcessor.Register(&C2S_Resume{})
+	JSONProcessor.Register(&S2C_Resume{})
 }
 
 // Close
 const (
-	S2C_Close_LoginRepeated = 1
-	S2C_Close_InnerError    = 2
+	S2C_Close_LoginRepeated  = 1
+	S2C_Close_InnerError     = 2
+	S2C_Close_ServerShutdown = 3
 )
 
 type S2C_Close struct {
@@ -39,5 +42,32 @@ const (
 )
 
 type S2C_Auth struct {
+	Err   int
+	Token string //断线重连的令牌
+}
+
+// Resume
+//断线重连，由网关处理，实现了gate.ResumeMsg
+type C2S_Resume struct {
+	Token string
+}
+
+func (m *C2S_Resume) ResumeToken() string {
+	return m.Token
+}
+
+func (m *C2S_Resume) ResumeReply(ok bool) interface{} {
+	if ok {
+		return &S2C_Resume{Err: S2C_Resume_OK}
+	}
+	return &S2C_Resume{Err: S2C_Resume_SessionInvalid}
+}
+
+const (
+	S2C_Resume_OK             = 0
+	S2C_Resume_SessionInvalid = 1 //会话已经结束，需要重新登录
+)
+
+type S2C_Resume struct {
 	Err int
 }